@@ -0,0 +1,209 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+
+	"github.com/pointlander/gradient/tf32"
+)
+
+// MixerWeights is the file the learned projections of the mixer are saved to
+const MixerWeights = "mixer.db"
+
+// MixerEta is the learning rate of the learned mixer
+const MixerEta = 1.0e-3
+
+// ProjectionNames are the names of the learned projections in the order they are stored
+var ProjectionNames = [...]string{"query", "key", "value", "output"}
+
+// ProjectionsLength is the size in bytes of the stored projections
+const ProjectionsLength = len(ProjectionNames) * 256 * 256 * 4
+
+// Projections are the learned query, key, value and output projections of the attention mixer
+type Projections struct {
+	Query  Matrix
+	Key    Matrix
+	Value  Matrix
+	Output Matrix
+}
+
+// Matrices returns the projections in the order they are stored
+func (p *Projections) Matrices() [len(ProjectionNames)]*Matrix {
+	return [...]*Matrix{&p.Query, &p.Key, &p.Value, &p.Output}
+}
+
+// LoadProjections loads the projections learned by LearnMixer
+func LoadProjections(name string) (*Projections, error) {
+	set := tf32.NewSet()
+	_, _, err := set.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	p := &Projections{}
+	for i, matrix := range p.Matrices() {
+		w, ok := set.ByName[ProjectionNames[i]]
+		if !ok || len(w.X) != 256*256 {
+			return nil, fmt.Errorf("%s: missing %s projection", name, ProjectionNames[i])
+		}
+		*matrix = NewMatrix(256, 256, w.X...)
+	}
+	return p, nil
+}
+
+// Append appends the projections as big endian float32
+func (p *Projections) Append(buffer []byte) []byte {
+	for _, matrix := range p.Matrices() {
+		for _, v := range matrix.Data {
+			buffer = binary.BigEndian.AppendUint32(buffer, math.Float32bits(v))
+		}
+	}
+	return buffer
+}
+
+// ReadProjections reads projections written by Append
+func ReadProjections(r io.Reader) (*Projections, error) {
+	buffer := make([]byte, ProjectionsLength)
+	if _, err := io.ReadFull(r, buffer); err != nil {
+		return nil, err
+	}
+	p := &Projections{}
+	for _, matrix := range p.Matrices() {
+		*matrix = NewMatrix(256, 256)
+		for i := 0; i < 256*256; i++ {
+			matrix.Data = append(matrix.Data, math.Float32frombits(binary.BigEndian.Uint32(buffer[4*i:])))
+		}
+		buffer = buffer[4*256*256:]
+	}
+	return p, nil
+}
+
+// Attend projects the contexts into queries, keys and values, attends, and projects the result
+func (p *Projections) Attend(x Matrix) Matrix {
+	q, k, v := p.Query.MulT(x), p.Key.MulT(x), p.Value.MulT(x)
+	return p.Output.MulT(SelfAttention(q, k, v))
+}
+
+// Mix mixes the contexts into a next symbol distribution
+func (p *Projections) Mix(x Matrix) Matrix {
+	y := p.Attend(x).Sum()
+	softmax(y.Data)
+	return y
+}
+
+// SoftmaxRows is the softmax of each row of a matrix
+func SoftmaxRows(k tf32.Continuation, node int, a *tf32.V, options ...map[string]interface{}) bool {
+	size, width := len(a.X), a.S[0]
+	c := tf32.NewV(a.S...)
+	for i := 0; i < size; i += width {
+		max := float32(math.Inf(-1))
+		for _, ax := range a.X[i : i+width] {
+			if ax > max {
+				max = ax
+			}
+		}
+		sum := float32(0.0)
+		for _, ax := range a.X[i : i+width] {
+			e := float32(math.Exp(float64(ax - max)))
+			c.X = append(c.X, e)
+			sum += e
+		}
+		for j := range c.X[i : i+width] {
+			c.X[i+j] /= sum
+		}
+	}
+	if k(&c) {
+		return true
+	}
+	for i := 0; i < size; i += width {
+		cx, cd := c.X[i:i+width], c.D[i:i+width]
+		dot := float32(0.0)
+		for j, d := range cd {
+			dot += d * cx[j]
+		}
+		for j, d := range cd {
+			a.D[i+j] += cx[j] * (d - dot)
+		}
+	}
+	return false
+}
+
+// LearnMixer learns the projections of the attention mixer with the contexts of the specs
+// The projections start near the identity, which is the unlearned mixer, and are trained to predict the next symbol
+func LearnMixer(data []byte, specs []Spec, steps int) error {
+	rng := rand.New(rand.NewSource(1))
+	trained := NewMixer(specs)
+	if err := trained.Train(bytes.NewReader(data), *FlagTable); err != nil {
+		return err
+	}
+
+	set := tf32.NewSet()
+	for _, name := range ProjectionNames {
+		set.Add(name, 256, 256)
+	}
+	for _, w := range set.Weights {
+		w.X = w.X[:cap(w.X)]
+		for i := range w.X {
+			w.X[i] = float32(rng.NormFloat64() * 1e-3)
+		}
+		for i := 0; i < 256; i++ {
+			w.X[i*256+i]++
+		}
+		w.States = make([][]float32, StateTotal)
+		for i := range w.States {
+			w.States[i] = make([]float32, len(w.X))
+		}
+	}
+
+	others := tf32.NewSet()
+	others.Add("input", 256, len(specs))
+	others.Add("output", 256)
+	for _, w := range others.Weights {
+		w.X = w.X[:cap(w.X)]
+	}
+
+	softmaxRows := tf32.U(SoftmaxRows)
+	query := tf32.Mul(set.Get("query"), others.Get("input"))
+	key := tf32.Mul(set.Get("key"), others.Get("input"))
+	value := tf32.Mul(set.Get("value"), others.Get("input"))
+	attention := tf32.T(tf32.Mul(softmaxRows(tf32.Mul(query, key)), tf32.T(value)))
+	output := tf32.Softmax(tf32.SumRows(tf32.Mul(set.Get("output"), attention)))
+	loss := tf32.CrossEntropy(output, others.Get("output"))
+
+	cost := float32(0.0)
+	for i := 0; i < steps; i++ {
+		index := rng.Intn(len(data) - 256)
+		m := trained.Copy()
+		end := index + 8 + rng.Intn(120)
+		for j := index; j < end; j++ {
+			m.Add(data[j])
+		}
+		copy(others.ByName["input"].X, m.Raw().Data)
+		target := others.ByName["output"].X
+		for j := range target {
+			target[j] = 0
+		}
+		target[data[end]] = 1
+
+		others.Zero()
+		set.Zero()
+		cost = tf32.Gradient(loss).X[0]
+		if math.IsNaN(float64(cost)) || math.IsInf(float64(cost), 0) {
+			return fmt.Errorf("learning the mixer diverged at step %d", i)
+		}
+		Adam(&set, i, MixerEta, func(name string) bool {
+			return true
+		})
+		if i%1024 == 0 {
+			fmt.Println(i, cost)
+		}
+	}
+	return set.Save(MixerWeights, cost, steps)
+}
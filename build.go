@@ -0,0 +1,274 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+)
+
+// Block is the number of records of a markov context that are ranked together
+const Block = 8 * 1024
+
+// Chunk is the number of symbols a worker mixes at a time
+const Chunk = 16 * 1024
+
+// chunk is a piece of the corpus to be mixed by a worker
+// Data holds the Warmup symbols preceding Start, the Count symbols to be mixed,
+// and the symbol following them
+type chunk struct {
+	Mixer  Mixer
+	State  *Mixer
+	Start  uint64
+	Warmup int
+	Count  int
+	Data   []byte
+	TXTs   chan []TXT
+}
+
+// mix makes the records of a chunk
+// A chunk with a state continues from it instead of warming up a copy of the mixer
+func (c *chunk) mix() []TXT {
+	m := c.State
+	if m == nil {
+		fresh := c.Mixer.Copy()
+		m = &fresh
+		for _, s := range c.Data[:c.Warmup] {
+			m.Add(s)
+		}
+	}
+	txts := make([]TXT, c.Count)
+	for i := range txts {
+		m.Add(c.Data[c.Warmup+i])
+		txt := &txts[i]
+		txt.Vector = m.MixFloat32()
+		txt.Markov = m.Markov
+		txt.Symbol = c.Data[c.Warmup+i+1]
+		txt.Index = c.Start + uint64(i)
+	}
+	return txts
+}
+
+// Vectors mixes every symbol of the corpus but the last and calls f with the records in corpus order
+// When the mixer state only depends on the largest window of preceding symbols, the corpus is
+// split into chunks that are warmed up with that many symbols and mixed in parallel by workers,
+// otherwise a single worker mixes the chunks in order with one copy of the mixer
+func Vectors(reader io.Reader, m Mixer, workers int, f func(txt *TXT) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+	window, state := Memory(m.Specs()), (*Mixer)(nil)
+	if window < 0 {
+		persistent := m.Copy()
+		window, state, workers = 0, &persistent, 1
+	}
+
+	jobs, results := make(chan *chunk, workers), make(chan *chunk, 2*workers)
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range jobs {
+				job.TXTs <- job.mix()
+			}
+		}()
+	}
+	errs := make(chan error, 1)
+	go func() {
+		defer close(results)
+		defer close(jobs)
+		start, history, previous := uint64(0), []byte{}, []byte{}
+		for {
+			current := make([]byte, Chunk)
+			n, err := io.ReadFull(reader, current)
+			current = current[:n]
+			last := err == io.EOF || err == io.ErrUnexpectedEOF
+			if err != nil && !last {
+				errs <- err
+				return
+			}
+			count := len(previous)
+			if len(current) == 0 {
+				count--
+			}
+			if count > 0 {
+				data := make([]byte, 0, len(history)+len(previous)+1)
+				data = append(data, history...)
+				data = append(data, previous...)
+				if len(current) > 0 {
+					data = append(data, current[0])
+				}
+				job := &chunk{
+					Mixer:  m,
+					State:  state,
+					Start:  start,
+					Warmup: len(history),
+					Count:  count,
+					Data:   data,
+					TXTs:   make(chan []TXT, 1),
+				}
+				select {
+				case results <- job:
+				case <-done:
+					return
+				}
+				jobs <- job
+				history = data[max(0, len(history)+len(previous)-window) : len(history)+len(previous)]
+				start += uint64(len(previous))
+			}
+			if last && len(current) == 0 {
+				errs <- nil
+				return
+			}
+			previous = current
+		}
+	}()
+	for job := range results {
+		for _, txt := range <-job.TXTs {
+			if err := f(&txt); err != nil {
+				return err
+			}
+		}
+	}
+	return <-errs
+}
+
+// Prepare trains the mixer on the corpus if it needs to be and returns a reader of the corpus
+// Hashed contexts are trained on the corpus before it is mixed, so the corpus is spooled to a temporary file
+// while they are trained and read back from it
+func Prepare(corpus io.Reader, m Mixer) (io.Reader, func() error, error) {
+	hashed := false
+	for _, spec := range m.Specs() {
+		hashed = hashed || spec.Hashed()
+	}
+	if !hashed {
+		return bufio.NewReader(corpus), func() error { return nil }, nil
+	}
+	spool, err := os.CreateTemp(*FlagTemp, "txt-corpus-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() error {
+		closed := spool.Close()
+		if err := os.Remove(spool.Name()); err != nil {
+			return err
+		}
+		return closed
+	}
+	writer := bufio.NewWriter(spool)
+	err = m.Train(io.TeeReader(corpus, writer), *FlagTable)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return bufio.NewReader(spool), cleanup, nil
+}
+
+// Build builds the vector database from the corpus
+// Records are generated in a single pass and sorted by markov context in bounded memory,
+// each markov context is ranked one block at a time, with the ranks of a block scaled by its length
+// so that they are relative to its average record and comparable across the blocks of a markov context,
+// and the ranked records are sorted again with their vectors encoded, since ranking is done,
+// before being written out after the header
+// A product quantisation codebook is learned from a reservoir sample of the vectors before ranking
+func Build(corpus io.Reader, m Mixer, encoding Encoding, db io.Writer) error {
+	markov := NewSorter(*FlagTemp, *FlagRun, ByMarkov)
+	defer markov.Close()
+
+	hash := sha256.New()
+	reader, cleanup, err := Prepare(io.TeeReader(corpus, hash), m)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	counts, sample, rng := make([]uint64, Buckets), [][256]float32{}, rand.New(rand.NewSource(1))
+	err = Vectors(reader, m, *FlagWorkers, func(txt *TXT) error {
+		counts[txt.Markov.Key()]++
+		if encoding == EncodingPQ {
+			if len(sample) < PQSample {
+				sample = append(sample, txt.Vector)
+			} else if i := rng.Int63n(int64(markov.Count) + 1); i < PQSample {
+				sample[i] = txt.Vector
+			}
+		}
+		return markov.Add(txt)
+	})
+	if err != nil {
+		return err
+	}
+
+	codec := &Codec{
+		Encoding: encoding,
+	}
+	if encoding == EncodingPQ {
+		codec.Codebook = LearnCodebook(sample, rng, *FlagWorkers)
+	}
+	ranker, ok := Rankers(*FlagNeighbors)[*FlagRank]
+	if !ok {
+		return fmt.Errorf("unknown ranking: %s", *FlagRank)
+	}
+	ranked := NewSorter(*FlagTemp, *FlagRun, ByRank)
+	ranked.Codec = codec
+	defer ranked.Close()
+	block := make([]TXT, 0, Block)
+	rank := func() error {
+		if len(block) == 0 {
+			return nil
+		}
+		ranker(block)
+		for i := range block {
+			block[i].Rank *= float64(len(block))
+			if err := ranked.Add(&block[i]); err != nil {
+				return err
+			}
+		}
+		block = block[:0]
+		return nil
+	}
+	err = markov.Merge(func(txt *TXT) error {
+		if len(block) > 0 && (block[0].Markov != txt.Markov || len(block) == Block) {
+			if err := rank(); err != nil {
+				return err
+			}
+		}
+		block = append(block, *txt)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := rank(); err != nil {
+		return err
+	}
+	if err := markov.Close(); err != nil {
+		return err
+	}
+
+	header := NewHeader(&m, codec)
+	copy(header.Hash[:], hash.Sum(nil))
+	header.Count = markov.Count
+	for i := 1; i < Buckets; i++ {
+		header.Index[i] = header.Index[i-1] + counts[i-1]
+	}
+	if err := header.Write(db); err != nil {
+		return err
+	}
+	writer := NewTXTWriter(db, codec)
+	err = ranked.MergeLines(writer.WriteLine)
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Context is a model of the preceding symbols that is mixed by the mixer
+type Context interface {
+	// Add adds a symbol to the context
+	Add(s byte)
+	// Distribution returns the 256 symbol distribution of the context
+	Distribution() []float32
+	// Reset forgets all of the symbols added
+	Reset()
+	// Clone makes an independent copy of the context
+	Clone() Context
+	// Spec describes the context
+	Spec() Spec
+}
+
+// Kind is the kind of a mixer context
+type Kind uint32
+
+const (
+	// KindWindow is a sliding window histogram
+	KindWindow Kind = iota
+	// KindDecay is an exponentially decaying histogram
+	KindDecay
+	// KindWord is a hashed context of the current partial word and the whole words before it
+	KindWord
+	// KindOrder is a hashed context of the preceding symbols in order
+	KindOrder
+)
+
+// Spec describes a context of the mixer
+type Spec struct {
+	Kind Kind
+	Size int
+}
+
+// String returns the kind and size of the context
+func (s Spec) String() string {
+	switch s.Kind {
+	case KindWindow:
+		return fmt.Sprintf("w%d", s.Size)
+	case KindDecay:
+		return fmt.Sprintf("d%d", s.Size)
+	case KindWord:
+		return fmt.Sprintf("t%d", s.Size)
+	case KindOrder:
+		return fmt.Sprintf("o%d", s.Size)
+	}
+	return fmt.Sprintf("unknown(%d)%d", uint32(s.Kind), s.Size)
+}
+
+// Position is where the context is embedded for the multi-head mixer, which distinguishes the kinds and sizes of contexts
+func (s Spec) Position() float64 {
+	return 32*float64(s.Kind) + math.Log2(float64(s.Size))
+}
+
+// Hashed is true if the context is looked up in a table learned from the corpus
+func (s Spec) Hashed() bool {
+	return s.Kind == KindWord || s.Kind == KindOrder
+}
+
+// Specs makes the specs of a mixer with histograms of the window sizes, decaying histograms of the half lives,
+// word contexts of the numbers of words, and order-n contexts of the orders
+func Specs(windows, halfLives, words, orders []int) []Spec {
+	specs := make([]Spec, 0, len(windows)+len(halfLives)+len(words)+len(orders))
+	for _, window := range windows {
+		specs = append(specs, Spec{Kind: KindWindow, Size: window})
+	}
+	for _, halfLife := range halfLives {
+		specs = append(specs, Spec{Kind: KindDecay, Size: halfLife})
+	}
+	for _, word := range words {
+		specs = append(specs, Spec{Kind: KindWord, Size: word})
+	}
+	for _, order := range orders {
+		specs = append(specs, Spec{Kind: KindOrder, Size: order})
+	}
+	return specs
+}
+
+// CheckSpecs checks that the contexts can be used by a mixer
+func CheckSpecs(specs []Spec) error {
+	if len(specs) == 0 {
+		return errors.New("the mixer has no contexts")
+	}
+	for _, spec := range specs {
+		if spec.Kind > KindOrder {
+			return fmt.Errorf("unknown context: %s", spec)
+		}
+		if spec.Kind == KindWord && spec.Size > MaxWords {
+			return fmt.Errorf("context %s has more than %d words", spec, MaxWords)
+		}
+		if spec.Kind == KindOrder && spec.Size > MaxHashedOrder {
+			return fmt.Errorf("context %s has an order larger than %d", spec, MaxHashedOrder)
+		}
+		if spec.Size < 1 || spec.Size > MaxWindowSize {
+			return fmt.Errorf("context %s size is not between 1 and %d", spec, MaxWindowSize)
+		}
+	}
+	return nil
+}
+
+// Memory is the number of preceding symbols the state of the contexts depends on, or -1 if it depends on all of them
+func Memory(specs []Spec) int {
+	memory := 0
+	for _, spec := range specs {
+		switch spec.Kind {
+		case KindWindow, KindOrder:
+			memory = max(memory, spec.Size)
+		case KindWord:
+			memory = max(memory, spec.Size*MaxWord)
+		default:
+			return -1
+		}
+	}
+	return memory
+}
+
+// ParseSizes parses a comma separated list of sizes
+func ParseSizes(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	sizes := make([]int, 0, len(parts))
+	for _, part := range parts {
+		size, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q: %w", part, err)
+		}
+		sizes = append(sizes, size)
+	}
+	return sizes, nil
+}
+
+// JoinSizes formats sizes as a comma separated list
+func JoinSizes(sizes []int) string {
+	parts := make([]string, len(sizes))
+	for i, size := range sizes {
+		parts[i] = strconv.Itoa(size)
+	}
+	return strings.Join(parts, ",")
+}
+
+// NewContext makes a new context from a spec
+func NewContext(spec Spec) Context {
+	switch spec.Kind {
+	case KindWindow:
+		h := NewHistogram(spec.Size)
+		return &h
+	case KindDecay:
+		d := NewDecay(spec.Size)
+		return &d
+	case KindWord, KindOrder:
+		h := NewHashed(spec)
+		return &h
+	}
+	panic(fmt.Errorf("unknown context: %s", spec))
+}
+
+// Train learns tables of 1<<bits slots for the hashed contexts of the mixer from a corpus
+func (m *Mixer) Train(reader io.Reader, bits int) error {
+	trainers := []*Hashed{}
+	for _, c := range m.Contexts {
+		if h, ok := c.(*Hashed); ok {
+			h.Table = NewTable(bits)
+			trainer := h.Clone().(*Hashed)
+			trainer.Reset()
+			trainers = append(trainers, trainer)
+		}
+	}
+	if len(trainers) == 0 {
+		return nil
+	}
+	buffered, first := bufio.NewReader(reader), true
+	for {
+		s, err := buffered.ReadByte()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for _, trainer := range trainers {
+			if !first {
+				trainer.Table.Count(trainer.Key, s)
+			}
+			trainer.Add(s)
+		}
+		first = false
+	}
+}
+
+// Tables returns the table of each context of the mixer, which is nil for contexts without one
+func (m *Mixer) Tables() []*Table {
+	tables := make([]*Table, len(m.Contexts))
+	for i, c := range m.Contexts {
+		if h, ok := c.(*Hashed); ok {
+			tables[i] = h.Table
+		}
+	}
+	return tables
+}
+
+// SetTables sets the tables of the hashed contexts of the mixer
+func (m *Mixer) SetTables(tables []*Table) {
+	for i, c := range m.Contexts {
+		if h, ok := c.(*Hashed); ok && i < len(tables) {
+			h.Table = tables[i]
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Bible is the name of the embedded default corpus
+const Bible = "10.txt.utf-8.bz2"
+
+// Inputs expands paths, directories and globs into a sorted list of files
+func Inputs(patterns []string) ([]string, error) {
+	files, seen := make([]string, 0, 8), make(map[string]bool)
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no such file or directory", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			err = filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.Type().IsRegular() {
+					add(path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
+
+// Decompress wraps a reader with a decompressor detected from the magic bytes
+func Decompress(in io.Reader) (io.Reader, func() error, error) {
+	reader := bufio.NewReader(in)
+	magic, err := reader.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	nop := func() error { return nil }
+	switch {
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(reader), nop, nil
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		decoder, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decoder, decoder.Close, nil
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return decoder, func() error {
+			decoder.Close()
+			return nil
+		}, nil
+	}
+	return reader, nop, nil
+}
+
+// Corpus is the concatenation of the input documents separated by a separator
+// TXT.Index is an offset into this stream, so the same inputs in the same order
+// with the same separator reconstruct the text a record points to
+type Corpus struct {
+	Files     []string
+	Separator []byte
+	part      int
+	reader    io.Reader
+	closers   []func() error
+}
+
+// OpenCorpus opens the files matched by patterns as a single stream, or the embedded bible if there are none
+func OpenCorpus(patterns []string, separator []byte) (*Corpus, error) {
+	files, err := Inputs(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &Corpus{
+		Files:     files,
+		Separator: separator,
+	}, nil
+}
+
+func (c *Corpus) open(name string) error {
+	var file fs.File
+	var err error
+	if len(c.Files) == 0 {
+		file, err = Iris.Open(name)
+	} else {
+		file, err = os.Open(name)
+	}
+	if err != nil {
+		return err
+	}
+	reader, closer, err := Decompress(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	c.reader, c.closers = reader, []func() error{closer, file.Close}
+	return nil
+}
+
+func (c *Corpus) close() error {
+	var first error
+	for _, closer := range c.closers {
+		if err := closer(); err != nil && first == nil {
+			first = err
+		}
+	}
+	c.reader, c.closers = nil, nil
+	return first
+}
+
+// Read reads from the current document, moving to the next one at the end
+func (c *Corpus) Read(p []byte) (int, error) {
+	files := c.Files
+	if len(files) == 0 {
+		files = []string{Bible}
+	}
+	for {
+		if c.reader == nil {
+			if c.part >= 2*len(files)-1 {
+				return 0, io.EOF
+			}
+			if c.part%2 == 1 {
+				c.reader = bytes.NewReader(c.Separator)
+			} else if err := c.open(files[c.part/2]); err != nil {
+				return 0, fmt.Errorf("%s: %w", files[c.part/2], err)
+			}
+			c.part++
+		}
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			if err := c.close(); err != nil {
+				return n, err
+			}
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close closes the current document
+func (c *Corpus) Close() error {
+	return c.close()
+}
+
+// ParseSeparator parses a document separator written with Go escapes
+func ParseSeparator(separator string) ([]byte, error) {
+	s, err := strconv.Unquote(`"` + separator + `"`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid separator %q: %w", separator, err)
+	}
+	return []byte(s), nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Record is a txt record that points into a mapped vector database
+// The encoded vector is followed by Meta bytes, so the fields are found from the end of the record
+type Record []byte
+
+// meta is everything but the vector
+func (r Record) meta() []byte {
+	return r[len(r)-Meta:]
+}
+
+// Vector is the encoded mixed vector of the record
+func (r Record) Vector() []byte {
+	return r[:len(r)-Meta]
+}
+
+// Markov is the markov context of the record
+func (r Record) Markov() Markov {
+	meta := r.meta()
+	return Markov{meta[0], meta[1]}
+}
+
+// Symbol is the symbol following the context of the record
+func (r Record) Symbol() byte {
+	return r.meta()[2]
+}
+
+// Index is the position of the record in the corpus
+func (r Record) Index() uint64 {
+	return binary.BigEndian.Uint64(r.meta()[3:11])
+}
+
+// Rank is the centrality of the record relative to the average of the block of its markov context it was ranked with
+func (r Record) Rank() float64 {
+	return byteToFloat64(r.meta()[11:19])
+}
+
+// DB is a vector database mapped into memory
+type DB struct {
+	Header  Header
+	Data    []byte
+	Records []byte
+	unmap   func() error
+}
+
+// OpenDB maps a vector database into memory and reads its header
+func OpenDB(name string) (*DB, error) {
+	data, unmap, err := Map(name)
+	if err != nil {
+		return nil, err
+	}
+	header, err := ReadHeader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	start, end := header.Length(), header.Length()+int64(header.Count)*int64(header.Line)
+	if end > int64(len(data)) {
+		unmap()
+		return nil, fmt.Errorf("%s: %w", name, ErrTruncated)
+	}
+	return &DB{
+		Header:  header,
+		Data:    data,
+		Records: data[start:end],
+		unmap:   unmap,
+	}, nil
+}
+
+// Len is the number of records
+func (d *DB) Len() int {
+	return int(d.Header.Count)
+}
+
+// Record returns the record at index without copying
+func (d *DB) Record(index int) Record {
+	line := int(d.Header.Line)
+	return Record(d.Records[index*line : (index+1)*line])
+}
+
+// Close unmaps the vector database
+func (d *DB) Close() error {
+	d.Records, d.Data = nil, nil
+	return d.unmap()
+}
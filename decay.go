@@ -0,0 +1,64 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+)
+
+// Decay is a histogram whose counts decay exponentially, halving every HalfLife symbols
+type Decay struct {
+	Vector   [256]float32
+	Lambda   float32
+	HalfLife int
+}
+
+// NewDecay makes a new decaying histogram
+func NewDecay(halfLife int) Decay {
+	return Decay{
+		Lambda:   float32(math.Pow(2, -1/float64(halfLife))),
+		HalfLife: halfLife,
+	}
+}
+
+// Add decays the counts and adds a symbol to the histogram
+func (d *Decay) Add(s byte) {
+	for i, v := range d.Vector {
+		v *= d.Lambda
+		if v < 1e-30 {
+			v = 0
+		}
+		d.Vector[i] = v
+	}
+	d.Vector[s]++
+}
+
+// Distribution returns the normalized decayed counts
+func (d *Decay) Distribution() []float32 {
+	distribution, sum := make([]float32, 256), float32(0.0)
+	for _, v := range d.Vector {
+		sum += v
+	}
+	for i, v := range d.Vector {
+		distribution[i] = v / sum
+	}
+	return distribution
+}
+
+// Reset empties the histogram
+func (d *Decay) Reset() {
+	d.Vector = [256]float32{}
+}
+
+// Clone copies the histogram
+func (d *Decay) Clone() Context {
+	c := *d
+	return &c
+}
+
+// Spec describes the histogram
+func (d *Decay) Spec() Spec {
+	return Spec{Kind: KindDecay, Size: d.HalfLife}
+}
@@ -0,0 +1,415 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
+)
+
+// Encoding is the quantisation of the vectors
+type Encoding uint32
+
+const (
+	// EncodingUint8 is a byte per dimension scaled by 255
+	EncodingUint8 Encoding = iota
+	// EncodingFloat32 is a big endian float32 per dimension
+	EncodingFloat32
+	// EncodingFloat16 is a big endian half precision float per dimension
+	EncodingFloat16
+	// EncodingInt8 is a float32 scale followed by a signed byte per dimension
+	EncodingInt8
+	// EncodingPQ is a product quantisation code per subspace
+	EncodingPQ
+)
+
+// Encodings are the encodings by name
+var Encodings = map[string]Encoding{
+	"uint8":   EncodingUint8,
+	"float32": EncodingFloat32,
+	"float16": EncodingFloat16,
+	"int8":    EncodingInt8,
+	"pq":      EncodingPQ,
+}
+
+// String returns the name of the encoding
+func (e Encoding) String() string {
+	for name, encoding := range Encodings {
+		if encoding == e {
+			return name
+		}
+	}
+	return fmt.Sprintf("unknown(%d)", uint32(e))
+}
+
+// Width is the number of bytes of an encoded vector, or 0 for an unknown encoding
+func (e Encoding) Width() int {
+	switch e {
+	case EncodingUint8:
+		return 256
+	case EncodingFloat32:
+		return 4 * 256
+	case EncodingFloat16:
+		return 2 * 256
+	case EncodingInt8:
+		return 4 + 256
+	case EncodingPQ:
+		return Subspaces
+	}
+	return 0
+}
+
+// Line is the size of a record with an encoded vector
+func (e Encoding) Line() int {
+	return e.Width() + Meta
+}
+
+// Codec encodes vectors and compares queries with encoded vectors
+type Codec struct {
+	Encoding Encoding
+	Codebook *Codebook
+}
+
+// Encode encodes a vector into a buffer of Width bytes
+func (c *Codec) Encode(vector *[256]float32, buffer []byte) {
+	switch c.Encoding {
+	case EncodingUint8:
+		for i, v := range vector {
+			buffer[i] = byte(min(max(255*v, 0), 255))
+		}
+	case EncodingFloat32:
+		for i, v := range vector {
+			binary.BigEndian.PutUint32(buffer[4*i:], math.Float32bits(v))
+		}
+	case EncodingFloat16:
+		for i, v := range vector {
+			binary.BigEndian.PutUint16(buffer[2*i:], Float16(v))
+		}
+	case EncodingInt8:
+		largest := float32(0.0)
+		for _, v := range vector {
+			largest = max(largest, float32(math.Abs(float64(v))))
+		}
+		scale := largest / 127
+		binary.BigEndian.PutUint32(buffer, math.Float32bits(scale))
+		for i, v := range vector {
+			q := float32(0.0)
+			if scale > 0 {
+				q = float32(math.Round(float64(v / scale)))
+			}
+			buffer[4+i] = byte(int8(min(max(q, -127), 127)))
+		}
+	case EncodingPQ:
+		c.Codebook.Encode(vector, buffer)
+	}
+}
+
+// Decode decodes a buffer of Width bytes into an approximation of the encoded vector
+func (c *Codec) Decode(buffer []byte, vector *[256]float32) {
+	switch c.Encoding {
+	case EncodingUint8:
+		for i := range vector {
+			vector[i] = float32(buffer[i]) / 255
+		}
+	case EncodingFloat32:
+		for i := range vector {
+			vector[i] = math.Float32frombits(binary.BigEndian.Uint32(buffer[4*i:]))
+		}
+	case EncodingFloat16:
+		for i := range vector {
+			vector[i] = Float32(binary.BigEndian.Uint16(buffer[2*i:]))
+		}
+	case EncodingInt8:
+		scale := math.Float32frombits(binary.BigEndian.Uint32(buffer))
+		for i := range vector {
+			vector[i] = scale * float32(int8(buffer[4+i]))
+		}
+	case EncodingPQ:
+		c.Codebook.Decode(buffer, vector)
+	}
+}
+
+// Similarity returns the metric of the query with encoded vectors, which are decoded into a scratch vector
+// Cosine similarity is specialised for each encoding and works on the encoded vectors directly,
+// using the squared norm of the query computed once
+func (c *Codec) Similarity(metric Metric, query *[256]float32) func(encoded []byte, scratch *[256]float32) float32 {
+	if _, ok := metric.(Cosine); !ok {
+		return func(encoded []byte, scratch *[256]float32) float32 {
+			c.Decode(encoded, scratch)
+			return metric.Similarity(query, scratch)
+		}
+	}
+	aa := dot(query[:], query[:])
+	switch c.Encoding {
+	case EncodingFloat32:
+		return func(encoded []byte, _ *[256]float32) float32 {
+			return CSFloat32(query, aa, encoded)
+		}
+	case EncodingFloat16:
+		return func(encoded []byte, _ *[256]float32) float32 {
+			return CSFloat16(query, aa, encoded)
+		}
+	case EncodingInt8:
+		return func(encoded []byte, _ *[256]float32) float32 {
+			return CSInt8(query, aa, encoded)
+		}
+	case EncodingPQ:
+		similarity := c.Codebook.Similarity(query)
+		return func(encoded []byte, _ *[256]float32) float32 {
+			return similarity(encoded)
+		}
+	}
+	return func(encoded []byte, _ *[256]float32) float32 {
+		return CSUint8(query, aa, encoded)
+	}
+}
+
+// cs is the cosine similarity from the dot product and the squared norms
+func cs(ab, aa, bb float32) float32 {
+	return ab / (float32(math.Sqrt(float64(aa))) * float32(math.Sqrt(float64(bb))))
+}
+
+// CSUint8 is the cosine similarity of a float32 vector with squared norm aa and a uint8 encoded vector
+func CSUint8(vector *[256]float32, aa float32, encoded []byte) float32 {
+	ab, bb := Accelerated.DotNormUint8(vector[:], encoded[:256])
+	return cs(ab, aa, bb)
+}
+
+// CSFloat32 is the cosine similarity of a float32 vector with squared norm aa and a float32 encoded vector
+func CSFloat32(vector *[256]float32, aa float32, encoded []byte) float32 {
+	ab, bb := Accelerated.DotNormFloat32(vector[:], encoded[:4*256])
+	return cs(ab, aa, bb)
+}
+
+// CSFloat16 is the cosine similarity of a float32 vector with squared norm aa and a float16 encoded vector
+func CSFloat16(vector *[256]float32, aa float32, encoded []byte) float32 {
+	bb, ab := float32(0.0), float32(0.0)
+	for i, a := range vector {
+		b := Float32(binary.BigEndian.Uint16(encoded[2*i:]))
+		bb += b * b
+		ab += a * b
+	}
+	return cs(ab, aa, bb)
+}
+
+// CSInt8 is the cosine similarity of a float32 vector with squared norm aa and an int8 encoded vector
+// The cosine does not depend on the positive scale, so it is skipped
+func CSInt8(vector *[256]float32, aa float32, encoded []byte) float32 {
+	ab, bb := Accelerated.DotNormInt8(vector[:], encoded[4:4+256])
+	return cs(ab, aa, bb)
+}
+
+// Float16 converts a float32 to the nearest half precision float
+func Float16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int(bits>>23&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+	switch {
+	case bits&0x7fffffff > 0x7f800000:
+		return sign | 0x7e00
+	case exponent >= 0x1f:
+		return sign | 0x7c00
+	case exponent <= 0:
+		if exponent < -10 {
+			return sign
+		}
+		mantissa |= 0x800000
+		shift := uint(14 - exponent)
+		half := uint32(1) << (shift - 1)
+		rounded := mantissa >> shift
+		if rest := mantissa & (1<<shift - 1); rest > half || (rest == half && rounded&1 == 1) {
+			rounded++
+		}
+		return sign | uint16(rounded)
+	}
+	rounded := uint32(exponent)<<10 | mantissa>>13
+	if rest := mantissa & 0x1fff; rest > 0x1000 || (rest == 0x1000 && rounded&1 == 1) {
+		rounded++
+	}
+	return sign | uint16(rounded)
+}
+
+// Float32 converts a half precision float to a float32
+func Float32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exponent := uint32(h>>10) & 0x1f
+	mantissa := uint32(h & 0x3ff)
+	switch {
+	case exponent == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mantissa<<13)
+	case exponent == 0:
+		if mantissa == 0 {
+			return math.Float32frombits(sign)
+		}
+		f := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			return -f
+		}
+		return f
+	}
+	return math.Float32frombits(sign | (exponent+127-15)<<23 | mantissa<<13)
+}
+
+const (
+	// Subspaces is the number of subspaces of product quantisation, each coded with a byte
+	Subspaces = 32
+	// Subspace is the number of dimensions of a subspace
+	Subspace = 256 / Subspaces
+	// Centroids is the number of centroids of a subspace
+	Centroids = 256
+	// PQSample is the number of vectors sampled to learn the codebook
+	PQSample = 4096
+	// PQIterations is the number of k-means iterations used to learn the codebook
+	PQIterations = 16
+)
+
+// CodebookLength is the size in bytes of a stored codebook
+const CodebookLength = Subspaces * Centroids * Subspace * 4
+
+// Codebook holds the centroids of each subspace of product quantisation
+type Codebook struct {
+	Centroids [Subspaces][Centroids][Subspace]float32
+}
+
+// nearest returns the centroid of the subspace nearest to x
+func (c *Codebook) nearest(subspace int, x []float32) byte {
+	code, best := 0, float32(math.Inf(1))
+	for i := range c.Centroids[subspace] {
+		centroid, d := &c.Centroids[subspace][i], float32(0.0)
+		for j, v := range x {
+			diff := v - centroid[j]
+			d += diff * diff
+		}
+		if d < best {
+			code, best = i, d
+		}
+	}
+	return byte(code)
+}
+
+// LearnCodebook learns the centroids of each subspace from a sample of vectors with k-means
+// The centroids start at randomly chosen vectors of the sample, and the subspaces are learned in parallel
+func LearnCodebook(sample [][256]float32, rng *rand.Rand, workers int) *Codebook {
+	c := &Codebook{}
+	if len(sample) == 0 {
+		return c
+	}
+	order := rng.Perm(len(sample))
+	subspaces := make(chan int, Subspaces)
+	for s := 0; s < Subspaces; s++ {
+		for i := range c.Centroids[s] {
+			copy(c.Centroids[s][i][:], sample[order[i%len(order)]][s*Subspace:])
+		}
+		subspaces <- s
+	}
+	close(subspaces)
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for s := range subspaces {
+				for iteration := 0; iteration < PQIterations; iteration++ {
+					sums, counts := [Centroids][Subspace]float32{}, [Centroids]int{}
+					for i := range sample {
+						x := sample[i][s*Subspace : (s+1)*Subspace]
+						code := c.nearest(s, x)
+						for j, v := range x {
+							sums[code][j] += v
+						}
+						counts[code]++
+					}
+					for i := range sums {
+						if counts[i] == 0 {
+							continue
+						}
+						for j := range sums[i] {
+							c.Centroids[s][i][j] = sums[i][j] / float32(counts[i])
+						}
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return c
+}
+
+// Encode codes each subspace of the vector with its nearest centroid
+func (c *Codebook) Encode(vector *[256]float32, codes []byte) {
+	for s := 0; s < Subspaces; s++ {
+		codes[s] = c.nearest(s, vector[s*Subspace:(s+1)*Subspace])
+	}
+}
+
+// Decode reconstructs a vector from its codes
+func (c *Codebook) Decode(codes []byte, vector *[256]float32) {
+	for s := 0; s < Subspaces; s++ {
+		copy(vector[s*Subspace:], c.Centroids[s][codes[s]][:])
+	}
+}
+
+// Similarity returns the cosine similarity of the query with coded vectors
+// The dot products of the query with every centroid and the squared norms of the centroids are computed once,
+// so each similarity is a sum of table lookups
+func (c *Codebook) Similarity(query *[256]float32) func(codes []byte) float32 {
+	dots, norms, aa := [Subspaces][Centroids]float32{}, [Subspaces][Centroids]float32{}, float32(0.0)
+	for _, a := range query {
+		aa += a * a
+	}
+	for s := 0; s < Subspaces; s++ {
+		q := query[s*Subspace : (s+1)*Subspace]
+		for i := range c.Centroids[s] {
+			centroid := &c.Centroids[s][i]
+			for j, v := range centroid {
+				dots[s][i] += q[j] * v
+				norms[s][i] += v * v
+			}
+		}
+	}
+	return func(codes []byte) float32 {
+		ab, bb := float32(0.0), float32(0.0)
+		for s, code := range codes[:Subspaces] {
+			ab += dots[s][code]
+			bb += norms[s][code]
+		}
+		return cs(ab, aa, bb)
+	}
+}
+
+// Append appends the centroids as big endian float32
+func (c *Codebook) Append(buffer []byte) []byte {
+	for s := range c.Centroids {
+		for i := range c.Centroids[s] {
+			for _, v := range c.Centroids[s][i] {
+				buffer = binary.BigEndian.AppendUint32(buffer, math.Float32bits(v))
+			}
+		}
+	}
+	return buffer
+}
+
+// ReadCodebook reads a codebook written by Append
+func ReadCodebook(r io.Reader) (*Codebook, error) {
+	buffer := make([]byte, CodebookLength)
+	if _, err := io.ReadFull(r, buffer); err != nil {
+		return nil, err
+	}
+	c := &Codebook{}
+	for s := range c.Centroids {
+		for i := range c.Centroids[s] {
+			for j := range c.Centroids[s][i] {
+				c.Centroids[s][i][j] = math.Float32frombits(binary.BigEndian.Uint32(buffer))
+				buffer = buffer[4:]
+			}
+		}
+	}
+	return c, nil
+}
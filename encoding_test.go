@@ -0,0 +1,172 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestFloat16(t *testing.T) {
+	cases := []struct {
+		F float32
+		H uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{0.5, 0x3800},
+		{0.333251953125, 0x3555},
+		{65504, 0x7bff},
+		{-65504, 0xfbff},
+		{6.103515625e-05, 0x0400},
+		// subnormals
+		{5.960464477539063e-08, 0x0001},
+		{6.097555160522461e-05, 0x03ff},
+		{-1.1920928955078125e-07, 0x8002},
+		// overflow to infinity
+		{65519, 0x7bff},
+		{65520, 0x7c00},
+		{1e10, 0x7c00},
+		{-1e10, 0xfc00},
+		{float32(math.Inf(1)), 0x7c00},
+		{float32(math.Inf(-1)), 0xfc00},
+		// underflow to zero
+		{1e-10, 0x0000},
+		{-1e-10, 0x8000},
+		// ties go to the even mantissa
+		{1 + 1.0/2048, 0x3c00},
+		{1 + 3.0/2048, 0x3c02},
+		{1 + 1.0/2048 + 1.0/65536, 0x3c01},
+		{2.9802322387695312e-08, 0x0000},
+		{8.940696716308594e-08, 0x0002},
+		{3.0e-08, 0x0001},
+	}
+	for _, c := range cases {
+		if h := Float16(c.F); h != c.H {
+			t.Errorf("Float16(%g) = %#04x, expected %#04x", c.F, h, c.H)
+		}
+	}
+	if h := Float16(float32(math.NaN())); !math.IsNaN(float64(Float32(h))) {
+		t.Errorf("NaN became %#04x", h)
+	}
+	for h := 0; h < 1<<16; h++ {
+		f := Float32(uint16(h))
+		if math.IsNaN(float64(f)) {
+			continue
+		}
+		if back := Float16(f); back != uint16(h) {
+			t.Fatalf("%#04x became %g and then %#04x", h, f, back)
+		}
+	}
+}
+
+// vector makes a random vector with values from -scale to scale
+func vector(rng *rand.Rand, scale float32) (v [256]float32) {
+	for i := range v {
+		v[i] = scale * (2*rng.Float32() - 1)
+	}
+	return v
+}
+
+// roundTrip encodes and decodes a vector with a codec
+func roundTrip(codec *Codec, v *[256]float32) (decoded [256]float32) {
+	buffer := make([]byte, codec.Encoding.Width())
+	codec.Encode(v, buffer)
+	codec.Decode(buffer, &decoded)
+	return decoded
+}
+
+func TestFloat32Codec(t *testing.T) {
+	v := vector(rand.New(rand.NewSource(1)), 1)
+	if decoded := roundTrip(&Codec{Encoding: EncodingFloat32}, &v); decoded != v {
+		t.Fatal("float32 vectors should be exact")
+	}
+}
+
+func TestFloat16Codec(t *testing.T) {
+	v := vector(rand.New(rand.NewSource(2)), 4)
+	decoded := roundTrip(&Codec{Encoding: EncodingFloat16}, &v)
+	for i := range v {
+		if math.Abs(float64(decoded[i]-v[i])) > math.Abs(float64(v[i]))/2048 {
+			t.Fatalf("dimension %d: %g decoded as %g", i, v[i], decoded[i])
+		}
+	}
+}
+
+func TestUint8Codec(t *testing.T) {
+	v := vector(rand.New(rand.NewSource(3)), 1.5)
+	decoded := roundTrip(&Codec{Encoding: EncodingUint8}, &v)
+	for i := range v {
+		clamped := min(max(v[i], 0), 1)
+		if decoded[i] > clamped || clamped-decoded[i] >= 1.0/255 {
+			t.Fatalf("dimension %d: %g decoded as %g", i, v[i], decoded[i])
+		}
+	}
+}
+
+func TestInt8Codec(t *testing.T) {
+	codec := &Codec{Encoding: EncodingInt8}
+	rng := rand.New(rand.NewSource(4))
+	for _, scale := range []float32{1e-6, 0.01, 1, 1000} {
+		v := vector(rng, scale)
+		v[rng.Intn(256)] = -scale
+		buffer := make([]byte, codec.Encoding.Width())
+		codec.Encode(&v, buffer)
+		if expected := scale / 127; math.Float32frombits(binary.BigEndian.Uint32(buffer)) != expected {
+			t.Fatalf("the scale of a vector with a largest magnitude of %g isn't %g", scale, expected)
+		}
+		decoded := [256]float32{}
+		codec.Decode(buffer, &decoded)
+		for i := range v {
+			if math.Abs(float64(decoded[i]-v[i])) > float64(scale)/254*1.0001 {
+				t.Fatalf("scale %g dimension %d: %g decoded as %g", scale, i, v[i], decoded[i])
+			}
+		}
+	}
+	zero := [256]float32{}
+	if decoded := roundTrip(codec, &zero); decoded != zero {
+		t.Fatal("the zero vector should decode as zeros")
+	}
+}
+
+func TestPQCodec(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	sample := make([][256]float32, 100)
+	for i := range sample {
+		sample[i] = vector(rng, 1)
+	}
+	codec := &Codec{
+		Encoding: EncodingPQ,
+		Codebook: LearnCodebook(sample, rng, 4),
+	}
+	for i := range sample {
+		if decoded := roundTrip(codec, &sample[i]); decoded != sample[i] {
+			t.Fatalf("vector %d of a sample smaller than the centroids should be exact", i)
+		}
+	}
+	v := vector(rng, 1)
+	codes := make([]byte, codec.Encoding.Width())
+	codec.Encode(&v, codes)
+	decoded := [256]float32{}
+	codec.Decode(codes, &decoded)
+	if again := roundTrip(codec, &decoded); again != decoded {
+		t.Fatal("a decoded vector should encode to the same centroids")
+	}
+	if s, expected := codec.Codebook.Similarity(&v)(codes), (Cosine{}).Similarity(&v, &decoded); math.Abs(float64(s-expected)) > 1e-4 {
+		t.Fatalf("similarity of the codes is %g, expected %g", s, expected)
+	}
+	read, err := ReadCodebook(bytes.NewReader(codec.Codebook.Append(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *read != *codec.Codebook {
+		t.Fatal("the codebook was not read back")
+	}
+}
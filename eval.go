@@ -0,0 +1,67 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// MetricBench compares the similarity metrics for next symbol accuracy at count evenly spaced positions of the corpus
+// The positions are searched like a query, backing off over the markov contexts and scored with the score if there is one,
+// and the neighbors vote for the next symbol
+// When the database was built from the corpus, the record made at each position is left out of its own search
+func MetricBench(data []byte, db *DB, count int, score Score, out io.Writer) error {
+	if len(data) < 2 || count < 1 {
+		return nil
+	}
+	stride := uint64(max(1, (len(data)-1)/count))
+	leaveOut := sha256.Sum256(data) == db.Header.Hash
+	queries := make([]TXT, 0, count)
+	err := Vectors(bytes.NewReader(data), db.Header.NewMixer(), *FlagWorkers, func(txt *TXT) error {
+		if txt.Index%stride == 0 && len(queries) < count {
+			queries = append(queries, *txt)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(Metrics))
+	for name := range Metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(out, "positions %d leave out %t\n", len(queries), leaveOut)
+	fmt.Fprintf(out, "%-10s %14s %10s\n", "metric", "time", "accuracy")
+	for _, name := range names {
+		correct, start := 0, time.Now()
+		for i := range queries {
+			txt := &queries[i]
+			ranges, _ := db.Backoff(txt.Markov, min(*FlagOrder, MaxOrder), *FlagWindow, *FlagMin)
+			query := Query{
+				Vector:   &txt.Vector,
+				K:        *FlagK,
+				Score:    score,
+				Weight:   *FlagPrior,
+				Metric:   Metrics[name],
+				LeaveOut: leaveOut,
+				Position: txt.Index,
+			}
+			neighbors := db.SearchRanges(&query, ranges, *FlagWorkers)
+			distribution := Vote(neighbors.Sorted(), Metrics[name], float32(*FlagVote))
+			if Argmax(&distribution) == txt.Symbol {
+				correct++
+			}
+		}
+		accuracy := float64(correct) / float64(len(queries))
+		fmt.Fprintf(out, "%-10s %14s %10.4f\n", name, time.Since(start), accuracy)
+	}
+	return nil
+}
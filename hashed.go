@@ -0,0 +1,234 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxWord is the number of preceding symbols a word context looks at for each word
+const MaxWord = 32
+
+// MaxWords is the largest number of words in a word context
+const MaxWords = 8
+
+// MaxHashedOrder is the largest order of an order-n context
+const MaxHashedOrder = 6
+
+// TableBits is the default log2 of the number of slots of a table
+const TableBits = 16
+
+// MaxTableBits is the largest log2 of the number of slots of a table, which is 256 MiB
+const MaxTableBits = 20
+
+// Table counts the symbols that follow the hashed context keys in a fixed number of slots
+// Keys that land in the same slot share its counts, and each count is a saturating byte,
+// so the counts of a slot are halved when one of them would overflow, keeping their proportions
+type Table struct {
+	Bits   int
+	Counts []byte
+}
+
+// NewTable makes a new empty table with 1<<bits slots
+func NewTable(bits int) *Table {
+	return &Table{
+		Bits:   bits,
+		Counts: make([]byte, 256<<bits),
+	}
+}
+
+// CheckTableBits checks that tables of 1<<bits slots can be made
+func CheckTableBits(bits int) error {
+	if bits < 1 || bits > MaxTableBits {
+		return fmt.Errorf("table bits %d is not between 1 and %d", bits, MaxTableBits)
+	}
+	return nil
+}
+
+// Slot returns the counts of the slot of the key
+func (t *Table) Slot(key uint32) []byte {
+	slot := int((key * 2654435761) >> (32 - t.Bits))
+	return t.Counts[slot<<8 : (slot+1)<<8]
+}
+
+// Count counts a symbol following the key
+func (t *Table) Count(key uint32, s byte) {
+	counts := t.Slot(key)
+	if counts[s] == 255 {
+		for i, count := range counts {
+			counts[i] = (count + 1) / 2
+		}
+	}
+	counts[s]++
+}
+
+// Used is the number of slots with counts
+func (t *Table) Used() int {
+	used := 0
+	for i := 0; i < len(t.Counts); i += 256 {
+		for _, count := range t.Counts[i : i+256] {
+			if count > 0 {
+				used++
+				break
+			}
+		}
+	}
+	return used
+}
+
+// Length is the size of the table in bytes
+func (t *Table) Length() int64 {
+	return 4 + int64(len(t.Counts))
+}
+
+// Append appends the number of bits and the counts of the table
+func (t *Table) Append(buffer []byte) []byte {
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(t.Bits))
+	return append(buffer, t.Counts...)
+}
+
+// ReadTable reads a table written by Append from a reader with at most remaining bytes left
+func ReadTable(r io.Reader, remaining int64) (*Table, error) {
+	buffer := make([]byte, 4)
+	if _, err := io.ReadFull(r, buffer); err != nil {
+		return nil, err
+	}
+	bits := int(binary.BigEndian.Uint32(buffer))
+	if err := CheckTableBits(bits); err != nil {
+		return nil, err
+	}
+	if length := 4 + int64(256)<<bits; length > remaining {
+		return nil, fmt.Errorf("table of %d bytes is longer than the %d bytes left", length, remaining)
+	}
+	t := NewTable(bits)
+	if _, err := io.ReadFull(r, t.Counts); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Letter is true if the symbol is part of a word, which includes the bytes of multi-byte characters
+func Letter(s byte) bool {
+	return (s >= 'a' && s <= 'z') || (s >= 'A' && s <= 'Z') || s >= 0x80
+}
+
+// Lower converts an ascii letter to lower case
+func Lower(s byte) byte {
+	if s >= 'A' && s <= 'Z' {
+		return s + 'a' - 'A'
+	}
+	return s
+}
+
+// Hashed is a context that predicts with the symbols that followed its hashed key in a table learned from the corpus
+// The key only depends on the last Memory symbols, which are kept in a circular buffer
+type Hashed struct {
+	Kind   Kind
+	Size   int
+	Buffer []byte
+	Index  int
+	Count  int
+	Key    uint32
+	Table  *Table
+}
+
+// NewHashed makes a new hashed context without a table
+func NewHashed(spec Spec) Hashed {
+	return Hashed{
+		Kind:   spec.Kind,
+		Size:   spec.Size,
+		Buffer: make([]byte, Memory([]Spec{spec})),
+	}
+}
+
+// at returns the symbol added i symbols ago
+func (h *Hashed) at(i int) byte {
+	return h.Buffer[(h.Index-i+len(h.Buffer))%len(h.Buffer)]
+}
+
+// key hashes the context with fnv-1a
+// An order-n context hashes the last Size symbols, and a word context hashes the current partial word
+// and the Size-1 whole words before it, ignoring case
+func (h *Hashed) key() uint32 {
+	key := uint32(2166136261)
+	hash := func(s byte) {
+		key ^= uint32(s)
+		key *= 16777619
+	}
+	if h.Kind == KindOrder {
+		for i := 0; i < h.Size; i++ {
+			if i < h.Count {
+				hash(h.at(i))
+			} else {
+				hash(0)
+			}
+		}
+		return key
+	}
+	i := 0
+	for word := 0; word < h.Size; word++ {
+		if word > 0 {
+			for i < h.Count && !Letter(h.at(i)) {
+				i++
+			}
+			hash(' ')
+		}
+		for i < h.Count && Letter(h.at(i)) {
+			hash(Lower(h.at(i)))
+			i++
+		}
+	}
+	return key
+}
+
+// Add adds a symbol to the context and hashes the new key
+func (h *Hashed) Add(s byte) {
+	h.Index = (h.Index + 1) % len(h.Buffer)
+	h.Buffer[h.Index] = s
+	if h.Count < len(h.Buffer) {
+		h.Count++
+	}
+	h.Key = h.key()
+}
+
+// Distribution returns the normalized counts of the symbols that followed the key, or zeros for an empty slot
+func (h *Hashed) Distribution() []float32 {
+	distribution := make([]float32, 256)
+	if h.Table == nil || h.Count == 0 {
+		return distribution
+	}
+	counts, sum := h.Table.Slot(h.Key), float32(0.0)
+	for _, v := range counts {
+		sum += float32(v)
+	}
+	if sum == 0 {
+		return distribution
+	}
+	for i, v := range counts {
+		distribution[i] = float32(v) / sum
+	}
+	return distribution
+}
+
+// Reset forgets the symbols added, keeping the table
+func (h *Hashed) Reset() {
+	clear(h.Buffer)
+	h.Index, h.Count, h.Key = 0, 0, 0
+}
+
+// Clone copies the context, sharing the table
+func (h *Hashed) Clone() Context {
+	c := *h
+	c.Buffer = make([]byte, len(h.Buffer))
+	copy(c.Buffer, h.Buffer)
+	return &c
+}
+
+// Spec describes the context
+func (h *Hashed) Spec() Spec {
+	return Spec{Kind: h.Kind, Size: h.Size}
+}
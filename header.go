@@ -0,0 +1,319 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Magic identifies a vector database file
+var Magic = [8]byte{'T', 'X', 'T', 'V', 'E', 'C', 'D', 'B'}
+
+// Version is the version of the vector database format
+const Version = 6
+
+// Buckets is the number of two byte markov contexts
+const Buckets = 256 * 256
+
+var (
+	// ErrMagic is returned when a file is not a vector database
+	ErrMagic = errors.New("not a vector database")
+	// ErrVersion is returned for an unsupported vector database version
+	ErrVersion = errors.New("unsupported vector database version")
+)
+
+// MixerType is the variant of the mixer used to make the vectors
+type MixerType uint32
+
+const (
+	// MixerAttention mixes the histograms with self attention
+	MixerAttention MixerType = iota
+	// MixerLearned mixes the histograms with self attention through learned projections
+	MixerLearned
+	// MixerMultiHead mixes the histograms and their embeddings with multi-head attention
+	MixerMultiHead
+)
+
+// String returns the name of the mixer
+func (m MixerType) String() string {
+	switch m {
+	case MixerAttention:
+		return "attention"
+	case MixerLearned:
+		return "learned"
+	case MixerMultiHead:
+		return "multihead"
+	}
+	return fmt.Sprintf("unknown(%d)", uint32(m))
+}
+
+// Header describes a vector database
+type Header struct {
+	Version     uint32
+	Contexts    []Spec
+	Mixer       MixerType
+	Encoding    Encoding
+	Width       uint32
+	Line        uint32
+	Hash        [32]byte
+	Count       uint64
+	Index       []uint64
+	Tables      []*Table
+	Projections *Projections
+	Heads       uint32
+	Codebook    *Codebook
+}
+
+// NewHeader makes a header for the mixer and the records encoded by the codec
+func NewHeader(m *Mixer, codec *Codec) Header {
+	return Header{
+		Version:     Version,
+		Contexts:    m.Specs(),
+		Tables:      m.Tables(),
+		Projections: m.Projections,
+		Heads:       uint32(m.Heads),
+		Mixer:       m.Type(),
+		Encoding:    codec.Encoding,
+		Width:       256,
+		Line:        uint32(codec.Encoding.Line()),
+		Codebook:    codec.Codebook,
+		Index:       make([]uint64, Buckets),
+	}
+}
+
+// Key is the position of the markov context in the index and in the sort order
+func (m Markov) Key() int {
+	return int(m[0])<<8 | int(m[1])
+}
+
+// Bucket returns the range of records with the markov context
+func (h *Header) Bucket(m Markov) (start, end uint64) {
+	key := m.Key()
+	start, end = h.Index[key], h.Count
+	if key+1 < len(h.Index) {
+		end = h.Index[key+1]
+	}
+	return start, end
+}
+
+// Length is the size of the header in bytes
+func (h *Header) Length() int64 {
+	length := int64(len(Magic) + 4 + 4 + 8*len(h.Contexts) + 4 + 4 + 4 + 4 + len(h.Hash) + 8 + 8*Buckets)
+	for i, spec := range h.Contexts {
+		if spec.Hashed() {
+			length += h.Tables[i].Length()
+		}
+	}
+	if h.Mixer == MixerLearned {
+		length += int64(ProjectionsLength)
+	} else if h.Mixer == MixerMultiHead {
+		length += 4
+	}
+	if h.Encoding == EncodingPQ {
+		length += CodebookLength
+	}
+	return length
+}
+
+// Write writes the header
+func (h *Header) Write(w io.Writer) error {
+	buffer := make([]byte, 0, h.Length())
+	buffer = append(buffer, Magic[:]...)
+	buffer = binary.BigEndian.AppendUint32(buffer, h.Version)
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(len(h.Contexts)))
+	for _, spec := range h.Contexts {
+		buffer = binary.BigEndian.AppendUint32(buffer, uint32(spec.Kind))
+		buffer = binary.BigEndian.AppendUint32(buffer, uint32(spec.Size))
+	}
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(h.Mixer))
+	buffer = binary.BigEndian.AppendUint32(buffer, uint32(h.Encoding))
+	buffer = binary.BigEndian.AppendUint32(buffer, h.Width)
+	buffer = binary.BigEndian.AppendUint32(buffer, h.Line)
+	buffer = append(buffer, h.Hash[:]...)
+	buffer = binary.BigEndian.AppendUint64(buffer, h.Count)
+	for _, start := range h.Index {
+		buffer = binary.BigEndian.AppendUint64(buffer, start)
+	}
+	for i, spec := range h.Contexts {
+		if spec.Hashed() {
+			buffer = h.Tables[i].Append(buffer)
+		}
+	}
+	if h.Mixer == MixerLearned {
+		buffer = h.Projections.Append(buffer)
+	} else if h.Mixer == MixerMultiHead {
+		buffer = binary.BigEndian.AppendUint32(buffer, h.Heads)
+	}
+	if h.Encoding == EncodingPQ {
+		buffer = h.Codebook.Append(buffer)
+	}
+	_, err := w.Write(buffer)
+	return err
+}
+
+// ReadHeader reads and validates the header of a vector database of length bytes
+func ReadHeader(r io.Reader, length int64) (Header, error) {
+	h := Header{}
+	buffer := make([]byte, len(Magic)+4+4)
+	if _, err := io.ReadFull(r, buffer); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return h, ErrMagic
+		}
+		return h, err
+	}
+	if [8]byte(buffer[:8]) != Magic {
+		return h, ErrMagic
+	}
+	h.Version = binary.BigEndian.Uint32(buffer[8:12])
+	if h.Version != Version {
+		return h, fmt.Errorf("%w: %d", ErrVersion, h.Version)
+	}
+	size := binary.BigEndian.Uint32(buffer[12:16])
+	if size > 1<<16 {
+		return h, fmt.Errorf("invalid number of contexts: %d", size)
+	}
+	buffer = make([]byte, 8*size+4+4+4+4+32+8+8*Buckets)
+	remaining := length - int64(len(Magic)+4+4+len(buffer))
+	if _, err := io.ReadFull(r, buffer); err != nil {
+		return h, err
+	}
+	h.Contexts = make([]Spec, size)
+	for i := range h.Contexts {
+		h.Contexts[i].Kind = Kind(binary.BigEndian.Uint32(buffer[8*i:]))
+		h.Contexts[i].Size = int(binary.BigEndian.Uint32(buffer[8*i+4:]))
+	}
+	buffer = buffer[8*size:]
+	h.Mixer = MixerType(binary.BigEndian.Uint32(buffer[0:4]))
+	h.Encoding = Encoding(binary.BigEndian.Uint32(buffer[4:8]))
+	h.Width = binary.BigEndian.Uint32(buffer[8:12])
+	h.Line = binary.BigEndian.Uint32(buffer[12:16])
+	copy(h.Hash[:], buffer[16:48])
+	h.Count = binary.BigEndian.Uint64(buffer[48:56])
+	buffer = buffer[56:]
+	h.Index = make([]uint64, Buckets)
+	for i := range h.Index {
+		h.Index[i] = binary.BigEndian.Uint64(buffer[8*i:])
+		if h.Index[i] > h.Count || (i > 0 && h.Index[i] < h.Index[i-1]) {
+			return h, fmt.Errorf("invalid index entry %d: %d", i, h.Index[i])
+		}
+	}
+	h.Tables = make([]*Table, size)
+	for i, spec := range h.Contexts {
+		if spec.Hashed() {
+			table, err := ReadTable(r, remaining)
+			if err != nil {
+				return h, err
+			}
+			h.Tables[i] = table
+			remaining -= table.Length()
+		}
+	}
+	if h.Mixer == MixerLearned {
+		projections, err := ReadProjections(r)
+		if err != nil {
+			return h, err
+		}
+		h.Projections = projections
+	} else if h.Mixer == MixerMultiHead {
+		buffer := make([]byte, 4)
+		if _, err := io.ReadFull(r, buffer); err != nil {
+			return h, err
+		}
+		h.Heads = binary.BigEndian.Uint32(buffer)
+	}
+	if h.Encoding == EncodingPQ {
+		codebook, err := ReadCodebook(r)
+		if err != nil {
+			return h, err
+		}
+		h.Codebook = codebook
+	}
+	return h, nil
+}
+
+// Codec returns the codec of the vectors of the records
+func (h *Header) Codec() *Codec {
+	return &Codec{
+		Encoding: h.Encoding,
+		Codebook: h.Codebook,
+	}
+}
+
+// NewMixer makes a mixer that makes the same vectors as the mixer the database was built with
+func (h *Header) NewMixer() Mixer {
+	m := NewMixer(h.Contexts)
+	m.SetTables(h.Tables)
+	m.Projections = h.Projections
+	m.Heads = int(h.Heads)
+	return m
+}
+
+// Check checks that the database can be read with the mixers and record layout of this binary
+func (h *Header) Check() error {
+	if err := CheckSpecs(h.Contexts); err != nil {
+		return err
+	}
+	switch h.Mixer {
+	case MixerAttention, MixerLearned:
+	case MixerMultiHead:
+		if err := CheckHeads(int(h.Heads)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported mixer: %s", h.Mixer)
+	}
+	if h.Encoding.Width() == 0 {
+		return fmt.Errorf("unsupported encoding: %s", h.Encoding)
+	}
+	if h.Width != 256 || int(h.Line) != h.Encoding.Line() {
+		return fmt.Errorf("unsupported record layout: width %d line %d", h.Width, h.Line)
+	}
+	return nil
+}
+
+// String returns a description of the header
+func (h *Header) String() string {
+	contexts := make([]string, len(h.Contexts))
+	for i, spec := range h.Contexts {
+		contexts[i] = spec.String()
+	}
+	var s strings.Builder
+	fmt.Fprintf(&s, "version:  %d\n", h.Version)
+	fmt.Fprintf(&s, "size:     %d\n", len(h.Contexts))
+	fmt.Fprintf(&s, "contexts: %s\n", strings.Join(contexts, ","))
+	fmt.Fprintf(&s, "mixer:    %s\n", h.Mixer)
+	if h.Mixer == MixerMultiHead {
+		fmt.Fprintf(&s, "heads:    %d\n", h.Heads)
+	}
+	fmt.Fprintf(&s, "encoding: %s\n", h.Encoding)
+	fmt.Fprintf(&s, "width:    %d\n", h.Width)
+	fmt.Fprintf(&s, "line:     %d\n", h.Line)
+	fmt.Fprintf(&s, "header:   %d\n", h.Length())
+	fmt.Fprintf(&s, "hash:     %s\n", hex.EncodeToString(h.Hash[:]))
+	fmt.Fprintf(&s, "count:    %d\n", h.Count)
+	buckets, largest := 0, uint64(0)
+	for i := range h.Index {
+		start, end := h.Bucket(Markov{byte(i >> 8), byte(i)})
+		if end > start {
+			buckets++
+		}
+		if end-start > largest {
+			largest = end - start
+		}
+	}
+	fmt.Fprintf(&s, "buckets:  %d\n", buckets)
+	fmt.Fprintf(&s, "largest:  %d\n", largest)
+	for i, spec := range h.Contexts {
+		if spec.Hashed() {
+			fmt.Fprintf(&s, "table %s: %d slots %d used\n", spec, 1<<h.Tables[i].Bits, h.Tables[i].Used())
+		}
+	}
+	return s.String()
+}
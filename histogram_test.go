@@ -0,0 +1,113 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// count counts the symbols of the last size bytes of data
+func count(data []byte, size int) (counts [256]uint32) {
+	for _, s := range data[max(0, len(data)-size):] {
+		counts[s]++
+	}
+	return counts
+}
+
+// symbols makes random data mixing random bytes, NUL bytes and long runs of one symbol
+func symbols(rng *rand.Rand, length int) []byte {
+	data := make([]byte, 0, length)
+	for len(data) < length {
+		switch rng.Intn(3) {
+		case 0:
+			for i := rng.Intn(64); i >= 0 && len(data) < length; i-- {
+				data = append(data, byte(rng.Intn(256)))
+			}
+		case 1:
+			for i := rng.Intn(8); i >= 0 && len(data) < length; i-- {
+				data = append(data, 0)
+			}
+		case 2:
+			s := byte(rng.Intn(256))
+			for i := rng.Intn(4 * length); i >= 0 && len(data) < length; i-- {
+				data = append(data, s)
+			}
+		}
+	}
+	return data
+}
+
+func TestHistogramCounts(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{1, 2, 255, 256, 257, 1000, 70000} {
+		for trial := 0; trial < 4; trial++ {
+			data := symbols(rng, 3*size+rng.Intn(512))
+			h := NewHistogram(size)
+			every := max(1, len(data)/256)
+			for i, s := range data {
+				h.Add(s)
+				if i%every != 0 && i != len(data)-1 && i != size-1 && i != size {
+					continue
+				}
+				if expected := count(data[:i+1], size); h.Vector != expected {
+					t.Fatalf("size %d trial %d: wrong counts after %d symbols", size, trial, i+1)
+				}
+			}
+		}
+	}
+}
+
+func TestHistogramRun(t *testing.T) {
+	for _, size := range []int{1, 255, 256, 70000} {
+		h := NewHistogram(size)
+		data := make([]byte, 0, 2*size+100000)
+		for i := 0; i < 100000; i++ {
+			data = append(data, 'a')
+			h.Add('a')
+		}
+		if expected := count(data, size); h.Vector != expected {
+			t.Fatalf("size %d: wrong counts after a run of one symbol", size)
+		}
+		for i := 0; i < 2*size; i++ {
+			data = append(data, 'b')
+			h.Add('b')
+			if i%max(1, size/16) == 0 {
+				if expected := count(data, size); h.Vector != expected {
+					t.Fatalf("size %d: wrong counts %d symbols after the run", size, i+1)
+				}
+			}
+		}
+		if h.Vector['a'] != 0 || h.Vector['b'] != uint32(size) {
+			t.Fatalf("size %d: the run was not evicted", size)
+		}
+	}
+}
+
+func TestHistogramCopyReset(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	data := symbols(rng, 4096)
+	h := NewHistogram(300)
+	for _, s := range data[:2048] {
+		h.Add(s)
+	}
+	c := h.Copy()
+	for _, s := range data[2048:] {
+		c.Add(s)
+	}
+	if expected := count(data[:2048], 300); h.Vector != expected {
+		t.Fatal("adding to a copy changed the original")
+	}
+	if expected := count(data, 300); c.Vector != expected {
+		t.Fatal("wrong counts in the copy")
+	}
+	h.Reset()
+	for _, s := range data[3000:] {
+		h.Add(s)
+	}
+	if expected := count(data[3000:], 300); h.Vector != expected {
+		t.Fatal("wrong counts after a reset")
+	}
+}
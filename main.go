@@ -5,26 +5,29 @@
 package main
 
 import (
-	"compress/bzip2"
+	"bufio"
+	"bytes"
 	"embed"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"math"
 	"math/rand"
 	"os"
-	"sort"
+	"runtime"
 	"strconv"
-
-	"github.com/alixaxel/pagerank"
 )
 
+// Windows are the default window sizes of the histograms
+var Windows = []int{1, 2, 4, 8, 16, 32, 64, 128}
+
 const (
-	// Size is the number of histograms
-	Size = 8
-	// Line is the size of a line
-	Line = 256 + 2 + 1 + 8
+	// Meta is the size of the markov context, symbol, index and rank that follow the vector of a record
+	Meta = 2 + 1 + 8 + 8
+	// Line is the size of a record with a full precision vector, which is how the build sorts them
+	Line = 4*256 + Meta
 )
 
 const (
@@ -51,119 +54,191 @@ var Iris embed.FS
 // Markov is a markov model
 type Markov [2]byte
 
+// MaxWindowSize is the largest histogram window
+const MaxWindowSize = 1 << 24
+
 // Histogram is a buffered histogram
+// Vector always holds the symbol counts of the last min(Count, Size) symbols added
 type Histogram struct {
-	Vector [256]byte
-	Buffer [128]byte
+	Vector [256]uint32
+	Buffer []byte
 	Index  int
+	Count  int
 	Size   int
 }
 
 // NewHistogram make a new histogram
 func NewHistogram(size int) Histogram {
 	h := Histogram{
-		Size: size,
+		Buffer: make([]byte, size),
+		Size:   size,
 	}
 	return h
 }
 
-// Add adds a symbol to the histogram
+// Copy copies the histogram
+func (h Histogram) Copy() Histogram {
+	buffer := make([]byte, len(h.Buffer))
+	copy(buffer, h.Buffer)
+	h.Buffer = buffer
+	return h
+}
+
+// Add adds a symbol to the histogram, evicting the oldest symbol once the buffer is full
 func (h *Histogram) Add(s byte) {
 	index := (h.Index + 1) % h.Size
-	if symbol := h.Buffer[index]; h.Vector[symbol] > 0 {
+	if h.Count == h.Size {
+		symbol := h.Buffer[index]
+		if h.Vector[symbol] == 0 {
+			panic(fmt.Errorf("histogram count of %d underflowed", symbol))
+		}
 		h.Vector[symbol]--
+	} else {
+		h.Count++
 	}
 	h.Buffer[index] = s
 	h.Vector[s]++
 	h.Index = index
 }
 
-// Mixer mixes several histograms together
+// Distribution returns the normalized symbol counts
+func (h *Histogram) Distribution() []float32 {
+	distribution, sum := make([]float32, 256), float32(0.0)
+	for _, v := range h.Vector {
+		sum += float32(v)
+	}
+	for i, v := range h.Vector {
+		distribution[i] = float32(v) / sum
+	}
+	return distribution
+}
+
+// Reset empties the histogram
+func (h *Histogram) Reset() {
+	h.Vector = [256]uint32{}
+	clear(h.Buffer)
+	h.Index, h.Count = 0, 0
+}
+
+// Clone copies the histogram
+func (h *Histogram) Clone() Context {
+	c := h.Copy()
+	return &c
+}
+
+// Spec describes the histogram
+func (h *Histogram) Spec() Spec {
+	return Spec{Kind: KindWindow, Size: h.Size}
+}
+
+// Mixer mixes several contexts together
+// The contexts are mixed with self attention, using the learned projections if there are any,
+// or with multi-head attention over the contexts and their embeddings if there are heads
 type Mixer struct {
-	Markov     Markov
-	Histograms []Histogram
-}
-
-// NewMixer makes a new mixer
-func NewMixer() Mixer {
-	histograms := make([]Histogram, Size)
-	histograms[0] = NewHistogram(1)
-	histograms[1] = NewHistogram(2)
-	histograms[2] = NewHistogram(4)
-	histograms[3] = NewHistogram(8)
-	histograms[4] = NewHistogram(16)
-	histograms[5] = NewHistogram(32)
-	histograms[6] = NewHistogram(64)
-	histograms[7] = NewHistogram(128)
-	return Mixer{
-		Histograms: histograms,
+	Markov      Markov
+	Contexts    []Context
+	Projections *Projections
+	Heads       int
+}
+
+// NewMixer makes a new mixer with the contexts of the specs
+func NewMixer(specs []Spec) Mixer {
+	m := Mixer{
+		Contexts: make([]Context, len(specs)),
+	}
+	for i, spec := range specs {
+		m.Contexts[i] = NewContext(spec)
 	}
+	return m
 }
 
+// Copy copies the mixer
 func (m Mixer) Copy() Mixer {
-	histograms := make([]Histogram, Size)
-	for i := range m.Histograms {
-		histograms[i] = m.Histograms[i]
+	contexts := make([]Context, len(m.Contexts))
+	for i := range m.Contexts {
+		contexts[i] = m.Contexts[i].Clone()
 	}
 	return Mixer{
-		Markov:     m.Markov,
-		Histograms: histograms,
+		Markov:      m.Markov,
+		Contexts:    contexts,
+		Projections: m.Projections,
+		Heads:       m.Heads,
 	}
 }
 
-// Raw returns the raw matrix
+// Reset resets the mixer to the start of a corpus
+func (m *Mixer) Reset() {
+	m.Markov = Markov{}
+	for i := range m.Contexts {
+		m.Contexts[i].Reset()
+	}
+}
+
+// Specs returns the specs of the contexts of the mixer
+func (m Mixer) Specs() []Spec {
+	specs := make([]Spec, len(m.Contexts))
+	for i := range m.Contexts {
+		specs[i] = m.Contexts[i].Spec()
+	}
+	return specs
+}
+
+// Raw returns the raw matrix with the distribution of each context as a row
 func (m Mixer) Raw() Matrix {
-	x := NewMatrix(256, Size)
-	for i := range m.Histograms {
-		sum := float32(0.0)
-		for _, v := range m.Histograms[i].Vector {
-			sum += float32(v)
-		}
-		for _, v := range m.Histograms[i].Vector {
-			x.Data = append(x.Data, float32(v)/sum)
-		}
+	x := NewMatrix(256, len(m.Contexts))
+	for i := range m.Contexts {
+		x.Data = append(x.Data, m.Contexts[i].Distribution()...)
 	}
 	return x
 }
 
-// Mix mixes the histograms
-func (m Mixer) Mix() [256]byte {
-	mix := [256]byte{}
-	x := NewMatrix(256, Size)
-	for i := range m.Histograms {
-		sum := float32(0.0)
-		for _, v := range m.Histograms[i].Vector {
-			sum += float32(v)
-		}
-		for _, v := range m.Histograms[i].Vector {
-			x.Data = append(x.Data, float32(v)/sum)
-		}
+// EmbeddingScale is the scale of the context embeddings relative to the context distributions
+const EmbeddingScale = 1.0 / 16
+
+// Embed adds the embedding of the spec of each context to its row, so the queries and keys of the rows
+// know which context they came from
+func (m Mixer) Embed(x Matrix) Matrix {
+	e := NewMatrix(x.Cols, x.Rows)
+	for i := range m.Contexts {
+		e.Data = append(e.Data, Embedding(m.Contexts[i].Spec().Position(), x.Cols, EmbeddingScale)...)
 	}
-	y := SelfAttention(x, x, x).Sum()
-	sum := float32(0.0)
-	for _, v := range y.Data {
-		sum += v
+	return x.Add(e)
+}
+
+// CheckHeads checks that the heads split the 256 dimensions evenly
+func CheckHeads(heads int) error {
+	if heads < 1 || heads > 256 || 256%heads != 0 {
+		return fmt.Errorf("%d heads do not divide 256 dimensions", heads)
 	}
-	for i := range mix {
-		mix[i] = byte(255 * y.Data[i] / sum)
+	return nil
+}
+
+// Type is the variant of the mixer
+func (m Mixer) Type() MixerType {
+	if m.Projections != nil {
+		return MixerLearned
+	} else if m.Heads > 0 {
+		return MixerMultiHead
 	}
-	return mix
+	return MixerAttention
+}
+
+// mix mixes the contexts into a single row
+func (m Mixer) mix() Matrix {
+	x := m.Raw()
+	if m.Projections != nil {
+		return m.Projections.Mix(x)
+	} else if m.Heads > 0 {
+		e := m.Embed(x)
+		return MultiHeadAttention(e, e, x, m.Heads).Sum()
+	}
+	return SelfAttention(x, x, x).Sum()
 }
 
 // MixFloat32 mixes the histograms outputting float64
 func (m Mixer) MixFloat32() [256]float32 {
 	mix := [256]float32{}
-	x := NewMatrix(256, Size)
-	for i := range m.Histograms {
-		sum := float32(0.0)
-		for _, v := range m.Histograms[i].Vector {
-			sum += float32(v)
-		}
-		for _, v := range m.Histograms[i].Vector {
-			x.Data = append(x.Data, float32(v)/sum)
-		}
-	}
-	y := SelfAttention(x, x, x).Sum()
+	y := m.mix()
 	sum := float32(0.0)
 	for _, v := range y.Data {
 		sum += v
@@ -176,15 +251,12 @@ func (m Mixer) MixFloat32() [256]float32 {
 
 // MixFloat32Vector mixes the histograms outputting float32
 func (m Mixer) MixFloat32Vector() Matrix {
-	x := NewMatrix(256, Size)
-	for i := range m.Histograms {
-		sum := float32(0.0)
-		for _, v := range m.Histograms[i].Vector {
-			sum += float32(v)
-		}
-		for _, v := range m.Histograms[i].Vector {
-			x.Data = append(x.Data, float32(v)/sum)
-		}
+	x := m.Raw()
+	if m.Projections != nil {
+		return m.Projections.Attend(x)
+	} else if m.Heads > 0 {
+		e := m.Embed(x)
+		return MultiHeadAttention(e, e, x, m.Heads)
 	}
 	y := SelfAttention(x, x, x)
 	return y
@@ -192,8 +264,8 @@ func (m Mixer) MixFloat32Vector() Matrix {
 
 // Add adds a symbol to a mixer
 func (m *Mixer) Add(s byte) {
-	for i := range m.Histograms {
-		m.Histograms[i].Add(s)
+	for i := range m.Contexts {
+		m.Contexts[i].Add(s)
 	}
 	m.Markov[1] = m.Markov[0]
 	m.Markov[0] = s
@@ -201,90 +273,174 @@ func (m *Mixer) Add(s byte) {
 
 // TXT is a context
 type TXT struct {
-	Vector [256]byte
+	Vector [256]float32
 	Markov Markov
 	Symbol byte
 	Index  uint64
 	Rank   float64
 }
 
-// TXTWriter is a txt file writer
+// ErrTruncated is returned when a file ends in the middle of a record
+var ErrTruncated = errors.New("truncated record")
+
+// Encode encodes a txt record into a buffer of Line bytes with a full precision vector
+func (t *TXT) Encode(buffer []byte) {
+	for i, v := range t.Vector {
+		binary.BigEndian.PutUint32(buffer[4*i:], math.Float32bits(v))
+	}
+	t.EncodeMeta(buffer[4*256:])
+}
+
+// Decode decodes a txt record from a buffer of Line bytes with a full precision vector
+func (t *TXT) Decode(buffer []byte) {
+	for i := range t.Vector {
+		t.Vector[i] = math.Float32frombits(binary.BigEndian.Uint32(buffer[4*i:]))
+	}
+	t.DecodeMeta(buffer[4*256:])
+}
+
+// EncodeMeta encodes everything but the vector into a buffer of Meta bytes
+func (t *TXT) EncodeMeta(buffer []byte) {
+	copy(buffer[0:2], t.Markov[:])
+	buffer[2] = t.Symbol
+	binary.BigEndian.PutUint64(buffer[3:11], t.Index)
+	copy(buffer[11:19], float64ToByte(t.Rank))
+}
+
+// DecodeMeta decodes everything but the vector from a buffer of Meta bytes
+func (t *TXT) DecodeMeta(buffer []byte) {
+	copy(t.Markov[:], buffer[0:2])
+	t.Symbol = buffer[2]
+	t.Index = binary.BigEndian.Uint64(buffer[3:11])
+	t.Rank = byteToFloat64(buffer[11:19])
+}
+
+// TXTWriter is a buffered txt record writer that encodes the vectors with a codec
 type TXTWriter struct {
-	File *os.File
+	Writer *bufio.Writer
+	Codec  *Codec
+	buffer []byte
 }
 
 // NewTXTWriter creates a new TXTWriter
-func NewTXTWriter(file *os.File) TXTWriter {
-	return TXTWriter{
-		File: file,
+func NewTXTWriter(writer io.Writer, codec *Codec) *TXTWriter {
+	return &TXTWriter{
+		Writer: bufio.NewWriter(writer),
+		Codec:  codec,
+		buffer: make([]byte, codec.Encoding.Line()),
 	}
 }
 
-// Write writes a txt record to the file
-func (t *TXTWriter) Write(txt *TXT) {
-	_, err := t.File.Write(txt.Vector[:])
-	if err != nil {
-		panic(err)
-	}
-	_, err = t.File.Write(txt.Markov[:])
-	if err != nil {
-		panic(err)
-	}
-	_, err = t.File.Write([]byte{txt.Symbol})
-	if err != nil {
-		panic(err)
+// Write writes a txt record
+func (t *TXTWriter) Write(txt *TXT) error {
+	width := t.Codec.Encoding.Width()
+	t.Codec.Encode(&txt.Vector, t.buffer[:width])
+	txt.EncodeMeta(t.buffer[width:])
+	_, err := t.Writer.Write(t.buffer)
+	return err
+}
+
+// WriteLine writes a txt record that is already encoded with the codec
+func (t *TXTWriter) WriteLine(line []byte) error {
+	if len(line) != len(t.buffer) {
+		return fmt.Errorf("record of %d bytes is not %d bytes", len(line), len(t.buffer))
 	}
-	index := make([]byte, 8)
-	for i := 0; i < 8; i++ {
-		index[i] = byte(txt.Index >> ((7 - i) * 8))
+	_, err := t.Writer.Write(line)
+	return err
+}
+
+// Flush writes any buffered records to the underlying writer
+func (t *TXTWriter) Flush() error {
+	return t.Writer.Flush()
+}
+
+// TXTReader is a buffered sequential txt record reader that decodes the vectors
+type TXTReader struct {
+	Reader *bufio.Reader
+	Header Header
+	buffer []byte
+}
+
+// NewTXTReader make a new TXTReader, reading and validating the header
+// The length of a stream isn't known, so only the limit on the size of the tables bounds the header
+func NewTXTReader(reader io.Reader) (*TXTReader, error) {
+	t := &TXTReader{
+		Reader: bufio.NewReader(reader),
 	}
-	_, err = t.File.Write(index)
+	header, err := ReadHeader(t.Reader, math.MaxInt64)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	t.Header = header
+	t.buffer = make([]byte, header.Line)
+	return t, nil
 }
 
-// TXTReader is a txt file reader
-type TXTReader struct {
-	File *os.File
+// decode decodes a record of the vector database
+func (h *Header) decode(buffer []byte, txt *TXT) {
+	width := len(buffer) - Meta
+	h.Codec().Decode(buffer[:width], &txt.Vector)
+	txt.DecodeMeta(buffer[width:])
 }
 
-// NewTXTReader make a new TXTReader
-func NewTXTReader(file *os.File) TXTReader {
-	return TXTReader{
-		File: file,
+// Read reads a txt record, returning io.EOF after the last record
+func (t *TXTReader) Read(txt *TXT) error {
+	_, err := io.ReadFull(t.Reader, t.buffer)
+	if err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	} else if err != nil {
+		return err
 	}
+	t.Header.decode(t.buffer, txt)
+	return nil
+}
+
+// TXTReaderAt is a random access txt record reader
+type TXTReaderAt struct {
+	ReaderAt io.ReaderAt
+	Header   Header
+	buffer   []byte
 }
 
-// Read reads a txt record
-func (t *TXTReader) Read(txt *TXT) bool {
-	buffer := make([]byte, Line)
-	n, _ := t.File.Read(buffer)
-	if n == 0 {
-		return true
+// NewTXTReaderAt make a new TXTReaderAt of size bytes, reading and validating the header
+func NewTXTReaderAt(reader io.ReaderAt, size int64) (*TXTReaderAt, error) {
+	header, err := ReadHeader(io.NewSectionReader(reader, 0, size), size)
+	if err != nil {
+		return nil, err
 	}
-	copy(txt.Vector[:], buffer[:256])
-	copy(txt.Markov[:], buffer[256:258])
-	txt.Symbol = buffer[258]
-	index := uint64(0)
-	for i := 0; i < 8; i++ {
-		index <<= 8
-		index |= uint64(buffer[259+i])
+	return &TXTReaderAt{
+		ReaderAt: reader,
+		Header:   header,
+		buffer:   make([]byte, header.Line),
+	}, nil
+}
+
+// Records returns a sequential reader of all of the records
+func (t *TXTReaderAt) Records() *TXTReader {
+	return &TXTReader{
+		Reader: bufio.NewReader(io.NewSectionReader(t.ReaderAt, t.Header.Length(), int64(t.Header.Count)*int64(t.Header.Line))),
+		Header: t.Header,
+		buffer: make([]byte, t.Header.Line),
 	}
-	txt.Index = index
-	return false
 }
 
-// Reset resets the reader to the beginning of the file
-func (t *TXTReader) Reset() {
-	_, err := t.File.Seek(0, 0)
-	if err != nil {
-		panic(err)
+// ReadAt reads the txt record at index, returning io.EOF past the last record
+func (t *TXTReaderAt) ReadAt(txt *TXT, index uint64) error {
+	if index >= t.Header.Count {
+		return io.EOF
 	}
+	n, err := t.ReaderAt.ReadAt(t.buffer, t.Header.Length()+int64(index)*int64(t.Header.Line))
+	if n == len(t.buffer) {
+		t.Header.decode(t.buffer, txt)
+		return nil
+	} else if n > 0 || err == io.EOF {
+		return ErrTruncated
+	}
+	return err
 }
 
 // CS is cosine similarity
-func (t *TXT) CS(vector *[256]byte) float64 {
+func (t *TXT) CS(vector *[256]float32) float64 {
 	aa, bb, ab := 0.0, 0.0, 0.0
 	for i := range vector {
 		a, b := float64(vector[i]), float64(t.Vector[i])
@@ -295,18 +451,6 @@ func (t *TXT) CS(vector *[256]byte) float64 {
 	return ab / (math.Sqrt(aa) * math.Sqrt(bb))
 }
 
-// CSFloat32 is float32 cosine similarity
-func (t *TXT) CSFloat32(vector *[256]float32) float32 {
-	aa, bb, ab := float32(0.0), float32(0.0), float32(0.0)
-	for i := range vector {
-		a, b := vector[i], float32(t.Vector[i])
-		aa += a * a
-		bb += b * b
-		ab += a * b
-	}
-	return ab / (float32(math.Sqrt(float64(aa))) * float32(math.Sqrt(float64(bb))))
-}
-
 // CSFloat64 is float64 cosine similarity
 func CSFloat64(t *[256]float64, vector *[256]float64) float64 {
 	aa, bb, ab := 0.0, 0.0, 0.0
@@ -341,8 +485,100 @@ var (
 	FlagNet = flag.Bool("net", false, "neural network mode")
 	// FlagCount number of symbols to generate
 	FlagCount = flag.Int("count", 33, "number of symbols to generate")
+	// FlagSeparator is the separator placed between input documents
+	FlagSeparator = flag.String("separator", `\n`, "separator placed between input documents, with Go escapes")
+	// FlagWorkers is the number of workers for building and brute force search
+	FlagWorkers = flag.Int("workers", runtime.GOMAXPROCS(0), "number of workers for building and brute force search")
+	// FlagK is the number of neighbors that vote for the next symbol
+	FlagK = flag.Int("k", 1, "number of nearest neighbors that vote for the next symbol")
+	// FlagVote is the temperature of the votes
+	FlagVote = flag.Float64("vote", 0, "temperature of the similarity weighted votes, 0 weights by similarity")
+	// FlagSample samples the next symbol instead of taking the most likely one
+	FlagSample = flag.Bool("sample", false, "sample the next symbol from the votes")
+	// FlagTemperature is the sampling temperature
+	FlagTemperature = flag.Float64("temperature", 1, "sampling temperature")
+	// FlagTopP is the probability mass of nucleus sampling
+	FlagTopP = flag.Float64("topp", 1, "sample from the most likely symbols with this much probability")
+	// FlagPenalty is the repetition penalty
+	FlagPenalty = flag.Float64("penalty", 1, "divide the probability of recently generated symbols by this penalty")
+	// FlagHistory is the number of recent symbols the repetition penalty applies to
+	FlagHistory = flag.Int("history", 16, "number of recent symbols the repetition penalty applies to")
+	// FlagSeed is the seed of the random number generator
+	FlagSeed = flag.Int64("seed", 1, "seed of the random number generator")
+	// FlagRank is the ranking algorithm used during the build
+	FlagRank = flag.String("rank", "pagerank", "ranking algorithm for the build: pagerank, knn or degree")
+	// FlagNeighbors is the number of neighbors linked to each record in the knn ranking
+	FlagNeighbors = flag.Int("neighbors", 16, "number of neighbors linked to each record by the knn ranking")
+	// FlagRankBench compares the ranking algorithms on the start of the corpus
+	FlagRankBench = flag.Int("rankbench", 0, "compare the ranking algorithms on this many bytes of the corpus")
+	// FlagScore is the formula combining similarity and rank
+	FlagScore = flag.String("score", "similarity", "scoring formula: similarity, product (similarity*rank^prior) or sum (similarity+prior*log(rank))")
+	// FlagPrior is the weight of the rank in the score
+	FlagPrior = flag.Float64("prior", 1, "weight of the relative rank in the score")
+	// FlagMetric is the similarity metric of the searches
+	FlagMetric = flag.String("metric", "cosine", "similarity metric of the searches: cosine, dot, l2, js, kl or hellinger")
+	// FlagMetricBench compares the similarity metrics for next symbol accuracy
+	FlagMetricBench = flag.Int("metricbench", 0, "compare the similarity metrics for next symbol accuracy on this many positions of the corpus")
+	// FlagWindow is the number of highest ranked records scanned per markov context
+	FlagWindow = flag.Int("window", 0, "number of highest ranked records scanned per markov context, 0 scans the whole context")
+	// FlagMin is the fewest records a markov context needs before backing off to a shorter one
+	FlagMin = flag.Int("min", 1, "fewest records a markov context needs before backing off to a shorter one")
+	// FlagOrder is the longest markov context tried by the approximate search
+	FlagOrder = flag.Int("order", MaxOrder, "longest markov context tried by the approximate search, at most 2")
+	// FlagWindows are the window sizes of the histograms of the mixer
+	FlagWindows = flag.String("windows", JoinSizes(Windows), "comma separated window sizes of the mixer histograms used by the build")
+	// FlagDecays are the half lives of the decaying histograms of the mixer
+	FlagDecays = flag.String("decays", "", "comma separated half lives of the mixer decaying histograms used by the build")
+	// FlagWords are the numbers of words of the word contexts of the mixer
+	FlagWords = flag.String("words", "", "comma separated numbers of words of the mixer word contexts used by the build, 1 is the partial word")
+	// FlagOrders are the orders of the order-n contexts of the mixer
+	FlagOrders = flag.String("orders", "", "comma separated orders of the mixer order-n contexts used by the build, from 1 to 6")
+	// FlagTable is the log2 of the number of slots of the tables of the hashed contexts
+	FlagTable = flag.Int("table", TableBits, "log2 of the number of slots of the tables of the word and order-n contexts")
+	// FlagMixer is the mixer used by the build and for learning
+	FlagMixer = flag.String("mixer", "attention", "the mixer: attention, multihead, or learned, which uses the projections learned with -neural -mixer learned")
+	// FlagHeads is the number of heads of the multi-head mixer
+	FlagHeads = flag.Int("heads", 4, "number of heads of the multihead mixer, which must divide 256")
+	// FlagSteps is the number of steps the learned mixer is trained for
+	FlagSteps = flag.Int("steps", 8*1024, "number of training steps of the learned mixer")
+	// FlagEncoding is the encoding of the vectors stored by the build
+	FlagEncoding = flag.String("encoding", "int8", "encoding of the stored vectors: int8, float16, float32, pq or uint8")
+	// FlagInspect prints the header of the vector database
+	FlagInspect = flag.Bool("inspect", false, "print the header of the vector database")
+	// FlagRun is the number of records held in memory during the build
+	FlagRun = flag.Int("run", 1<<18, "number of records per sorted run during the build")
+	// FlagSIMD uses the SIMD kernels when the cpu supports them
+	FlagSIMD = flag.Bool("simd", true, "use the SIMD kernels when the cpu supports them")
+	// FlagSIMDBench compares the SIMD kernels with the generic ones
+	FlagSIMDBench = flag.Int("simdbench", 0, "compare the SIMD kernels with the generic ones over this many iterations")
+	// FlagTemp is the directory for the sorted runs
+	FlagTemp = flag.String("tmp", "", "directory for the temporary sorted runs")
 )
 
+// OpenInputs opens the corpus given on the command line
+func OpenInputs() *Corpus {
+	separator, err := ParseSeparator(*FlagSeparator)
+	if err != nil {
+		panic(err)
+	}
+	corpus, err := OpenCorpus(flag.Args(), separator)
+	if err != nil {
+		panic(err)
+	}
+	return corpus
+}
+
+// ReadCorpus reads the whole corpus given on the command line
+func ReadCorpus() []byte {
+	corpus := OpenInputs()
+	defer corpus.Close()
+	data, err := io.ReadAll(corpus)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
 func float64ToByte(f float64) []byte {
 	var buf [8]byte
 	binary.BigEndian.PutUint64(buf[:], math.Float64bits(f))
@@ -356,125 +592,140 @@ func byteToFloat64(buf []byte) float64 {
 func main() {
 	flag.Parse()
 
-	if *FlagBuild || *FlagNeural {
-		file, err := Iris.Open("10.txt.utf-8.bz2")
-		if err != nil {
-			panic(err)
+	if !*FlagSIMD {
+		Accelerated = Generic
+	}
+	if *FlagSIMDBench > 0 {
+		SIMDBench(*FlagSIMDBench, os.Stdout)
+		return
+	}
+
+	windows, err := ParseSizes(*FlagWindows)
+	if err != nil {
+		panic(err)
+	}
+	halfLives, err := ParseSizes(*FlagDecays)
+	if err != nil {
+		panic(err)
+	}
+	words, err := ParseSizes(*FlagWords)
+	if err != nil {
+		panic(err)
+	}
+	orders, err := ParseSizes(*FlagOrders)
+	if err != nil {
+		panic(err)
+	}
+	specs := Specs(windows, halfLives, words, orders)
+	err = CheckSpecs(specs)
+	if err != nil {
+		panic(err)
+	}
+	err = CheckTableBits(*FlagTable)
+	if err != nil {
+		panic(err)
+	}
+
+	if *FlagNeural {
+		if *FlagMixer == "learned" {
+			err := LearnMixer(ReadCorpus(), specs, *FlagSteps)
+			if err != nil {
+				panic(err)
+			}
+			return
 		}
-		defer file.Close()
-		reader := bzip2.NewReader(file)
-		data, err := io.ReadAll(reader)
+		Learn(ReadCorpus(), specs)
+		return
+	}
+
+	switch *FlagMixer {
+	case "attention", "learned":
+	case "multihead":
+		err = CheckHeads(*FlagHeads)
 		if err != nil {
 			panic(err)
 		}
+	default:
+		panic(fmt.Errorf("unknown mixer: %s", *FlagMixer))
+	}
+	// newMixer makes the mixer of the flags, loading the learned projections from mixer.db,
+	// which only the modes that mix with it need, since queries take the mixer from the database
+	newMixer := func() Mixer {
+		mixer := NewMixer(specs)
+		switch *FlagMixer {
+		case "learned":
+			mixer.Projections, err = LoadProjections(MixerWeights)
+			if err != nil {
+				panic(err)
+			}
+		case "multihead":
+			mixer.Heads = *FlagHeads
+		}
+		return mixer
+	}
 
-		if *FlagNeural {
-			Learn(data)
-			return
+	if *FlagRankBench > 0 {
+		corpus := OpenInputs()
+		defer corpus.Close()
+		err := RankBench(io.LimitReader(corpus, int64(*FlagRankBench)), newMixer(), os.Stdout)
+		if err != nil {
+			panic(err)
 		}
+		return
+	}
 
+	if *FlagBuild {
+		mixer := newMixer()
+		corpus := OpenInputs()
+		defer corpus.Close()
 		db, err := os.Create("vectors.bin")
 		if err != nil {
 			panic(err)
 		}
 		defer db.Close()
-		m := NewMixer()
-		length := len(data) - 1
-		input, txts := data[:length], make([]TXT, length)
-		for i, s := range input {
-			m.Add(s)
-			txt := TXT{}
-			txt.Vector = m.Mix()
-			txt.Markov = m.Markov
-			txt.Symbol = data[i+1]
-			txt.Index = uint64(i)
-			txts[i] = txt
+		encoding, ok := Encodings[*FlagEncoding]
+		if !ok {
+			panic(fmt.Errorf("unknown encoding: %s", *FlagEncoding))
 		}
-
-		sort.Slice(txts, func(i, j int) bool {
-			if txts[i].Markov[0] < txts[j].Markov[0] {
-				return true
-			} else if txts[i].Markov[0] == txts[j].Markov[0] {
-				return txts[i].Markov[1] < txts[j].Markov[1]
-			}
-			return false
-		})
-
-		const Block = 8 * 1024
-		last, index := txts[0].Markov, 0
-		for i := range txts {
-			if last == txts[i].Markov {
-				continue
-			}
-
-			diff := i - index
-			blocks, spares := diff/Block, diff%Block
-			for j := 0; j < blocks; j++ {
-				graph := pagerank.NewGraph()
-				for k := 0; k < Block; k++ {
-					for l := 0; l < Block; l++ {
-						graph.Link(uint32(k), uint32(l), txts[index+j*Block+k].CS(&txts[index+j*Block+l].Vector))
-					}
-				}
-				graph.Rank(0.8, 1e-6, func(node uint32, rank float64) {
-					txts[index+j*Block+int(node)].Rank = rank
-				})
-			}
-			{
-				graph := pagerank.NewGraph()
-				for k := 0; k < spares; k++ {
-					for l := 0; l < spares; l++ {
-						graph.Link(uint32(k), uint32(l), txts[index+blocks*Block+k].CS(&txts[index+blocks*Block+l].Vector))
-					}
-				}
-				graph.Rank(0.8, 1e-6, func(node uint32, rank float64) {
-					txts[index+blocks*Block+int(node)].Rank = rank
-				})
-			}
-
-			last, index = txts[i].Markov, i
+		err = Build(corpus, mixer, encoding, db)
+		if err != nil {
+			panic(err)
 		}
+		return
+	}
 
-		sort.Slice(txts, func(i, j int) bool {
-			if txts[i].Markov[0] < txts[j].Markov[0] {
-				return true
-			} else if txts[i].Markov[0] == txts[j].Markov[0] {
-				if txts[i].Markov[1] < txts[j].Markov[1] {
-					return true
-				} else if txts[i].Markov[1] == txts[j].Markov[1] {
-					return txts[i].Rank > txts[j].Rank
-				}
+	if *FlagInspect {
+		vectors, err := os.Open("vectors.bin")
+		if err != nil {
+			panic(err)
+		}
+		defer vectors.Close()
+		info, err := vectors.Stat()
+		if err != nil {
+			panic(err)
+		}
+		reader, err := NewTXTReaderAt(vectors, info.Size())
+		if err != nil {
+			panic(err)
+		}
+		fmt.Print(reader.Header.String())
+		if count := reader.Header.Count; count > 0 {
+			if err := reader.ReadAt(&TXT{}, count-1); err != nil {
+				panic(err)
 			}
-			return false
-		})
-
-		writer := NewTXTWriter(db)
-		for _, txt := range txts {
-			writer.Write(&txt)
 		}
 		return
 	}
 
 	input := []byte(*FlagQuery)
 
-	vectors, err := os.Open("vectors.bin")
-	if err != nil {
-		panic(err)
-	}
-	defer vectors.Close()
-	m := NewMixer()
-	for _, s := range input {
-		m.Add(s)
-	}
 	if *FlagNet {
-		file, err := Iris.Open("10.txt.utf-8.bz2")
-		if err != nil {
-			panic(err)
+		m := newMixer()
+		for _, s := range input {
+			m.Add(s)
 		}
-		defer file.Close()
-		reader := bzip2.NewReader(file)
-		data, err := io.ReadAll(reader)
-		if err != nil {
+		data := ReadCorpus()
+		if err := m.Train(bytes.NewReader(data), *FlagTable); err != nil {
 			panic(err)
 		}
 		valid := make(map[byte]bool)
@@ -482,8 +733,7 @@ func main() {
 			valid[v] = true
 		}
 		fmt.Println("valid", len(valid))
-
-		rng := rand.New(rand.NewSource(1))
+		rng := rand.New(rand.NewSource(*FlagSeed))
 		neural := Load()
 		solution := make([]byte, 0, 8)
 		/*for i := 0; i < 33; i++ {
@@ -536,67 +786,66 @@ func main() {
 		fmt.Println(string(solution))
 		return
 	}
+	db, err := OpenDB("vectors.bin")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	err = db.Header.Check()
+	if err != nil {
+		panic(err)
+	}
+	metric, ok := Metrics[*FlagMetric]
+	if !ok {
+		panic(fmt.Errorf("unknown metric: %s", *FlagMetric))
+	}
+	score, ok := Scores[*FlagScore]
+	if !ok {
+		panic(fmt.Errorf("unknown score: %s", *FlagScore))
+	}
+	if *FlagScore == "similarity" {
+		score = nil
+	}
+	if *FlagMetricBench > 0 {
+		err := MetricBench(ReadCorpus(), db, *FlagMetricBench, score, os.Stdout)
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+	m := db.Header.NewMixer()
+	for _, s := range input {
+		m.Add(s)
+	}
+	sampler := NewSampler(*FlagSeed)
+	sampler.Sample = *FlagSample
+	sampler.Temperature = float32(*FlagTemperature)
+	sampler.TopP = float32(*FlagTopP)
+	sampler.Penalty = float32(*FlagPenalty)
+	sampler.Window = *FlagHistory
+	for _, s := range input {
+		sampler.Add(s)
+	}
+	next := func(neighbors *Neighbors) byte {
+		return sampler.Next(Vote(neighbors.Sorted(), metric, float32(*FlagVote)))
+	}
 	if *FlagBrute {
-		txt, reader := TXT{}, NewTXTReader(vectors)
 		for j := 0; j < *FlagCount; j++ {
 			vector := m.MixFloat32()
-			symbol, max := byte(0), float32(-1.0)
-			done := reader.Read(&txt)
-			for !done {
-				s := txt.CSFloat32(&vector)
-				if s > max {
-					max, symbol = s, txt.Symbol
-				}
-				done = reader.Read(&txt)
-			}
-			reader.Reset()
+			query := Query{Vector: &vector, K: *FlagK, Score: score, Weight: *FlagPrior, Metric: metric}
+			symbol := next(db.ParallelSearch(&query, 0, db.Len(), *FlagWorkers))
 			fmt.Printf("%d %s\n", symbol, strconv.Quote(string(symbol)))
 			m.Add(symbol)
 		}
 		return
 	}
-	stat, err := vectors.Stat()
-	if err != nil {
-		panic(err)
-	}
-	length := stat.Size() / Line
 	symbols := make([]byte, 0, 8)
-	txt, reader := TXT{}, NewTXTReader(vectors)
 	for j := 0; j < *FlagCount; j++ {
-		index := sort.Search(int(length), func(i int) bool {
-			_, err := vectors.Seek(int64(i*Line), 0)
-			if err != nil {
-				panic(err)
-			}
-			txt := TXT{}
-			reader.Read(&txt)
-			if txt.Markov[0] > m.Markov[0] {
-				return true
-			} else if txt.Markov[0] == m.Markov[0] {
-				return txt.Markov[1] >= m.Markov[1]
-			}
-			return false
-		})
-		symbol, max := byte(0), float32(-1.0)
+		ranges, order := db.Backoff(m.Markov, min(*FlagOrder, MaxOrder), *FlagWindow, *FlagMin)
 		vector := m.MixFloat32()
-		for k := 0; k < 2048; k++ {
-			index := index + k
-			if index < 0 {
-				continue
-			} else if index >= int(length) {
-				continue
-			}
-			_, err := vectors.Seek(int64(index*Line), 0)
-			if err != nil {
-				panic(err)
-			}
-			reader.Read(&txt)
-			s := txt.CSFloat32(&vector)
-			if s > max {
-				max, symbol = s, txt.Symbol
-			}
-		}
-		fmt.Printf("%d %s\n", symbol, strconv.Quote(string(symbol)))
+		query := Query{Vector: &vector, K: *FlagK, Score: score, Weight: *FlagPrior, Metric: metric}
+		symbol := next(db.SearchRanges(&query, ranges, *FlagWorkers))
+		fmt.Printf("%d %s order %d\n", symbol, strconv.Quote(string(symbol)), order)
 		m.Add(symbol)
 		symbols = append(symbols, symbol)
 	}
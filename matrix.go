@@ -35,11 +35,8 @@ func NewMatrix(cols, rows int, data ...float32) Matrix {
 }
 
 // Dot computes the dot product
-func dot(x, y []float32) (z float32) {
-	for i := range x {
-		z += x[i] * y[i]
-	}
-	return z
+func dot(x, y []float32) float32 {
+	return Accelerated.Dot(x, y)
 }
 
 // MulT multiplies two matrices and computes the transpose
@@ -179,6 +176,49 @@ func SelfAttention(Q, K, V Matrix) Matrix {
 	return o
 }
 
+// Columns returns the columns of the matrix from start up to end
+func (m Matrix) Columns(start, end int) Matrix {
+	o := NewMatrix(end-start, m.Rows)
+	for i := 0; i < m.Rows; i++ {
+		o.Data = append(o.Data, m.Data[i*m.Cols+start:i*m.Cols+end]...)
+	}
+	return o
+}
+
+// MultiHeadAttention computes the self attention of Q, K, V with the columns split evenly between the heads
+func MultiHeadAttention(Q, K, V Matrix, heads int) Matrix {
+	if Q.Cols%heads != 0 || V.Cols%heads != 0 {
+		panic(fmt.Errorf("%d heads do not divide %d and %d columns", heads, Q.Cols, V.Cols))
+	}
+	o := Matrix{
+		Cols: V.Cols,
+		Rows: K.Rows,
+		Data: make([]float32, V.Cols*K.Rows),
+	}
+	width, value := Q.Cols/heads, V.Cols/heads
+	for h := 0; h < heads; h++ {
+		head := SelfAttention(Q.Columns(h*width, (h+1)*width), K.Columns(h*width, (h+1)*width),
+			V.Columns(h*value, (h+1)*value))
+		for i := 0; i < head.Rows; i++ {
+			copy(o.Data[i*o.Cols+h*value:], head.Data[i*value:(i+1)*value])
+		}
+	}
+	return o
+}
+
+// Embedding is a sinusoidal embedding of a position
+func Embedding(position float64, cols int, scale float32) []float32 {
+	embedding := make([]float32, cols)
+	for i := 0; i < cols; i += 2 {
+		angle := position / math.Pow(10000, float64(i)/float64(cols))
+		embedding[i] = scale * float32(math.Sin(angle))
+		if i+1 < cols {
+			embedding[i+1] = scale * float32(math.Cos(angle))
+		}
+	}
+	return embedding
+}
+
 // MakeRandomTransform makes a random transform
 func MakeRandomTransform(rng *rand.Rand, cols, rows int, stddev float32) Matrix {
 	transform := NewMatrix(cols, rows)
@@ -0,0 +1,128 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+)
+
+// Metric scores how similar a query is to the vector of a record, with larger scores being more similar
+// Distances and divergences are negated so they can be used like similarities
+type Metric interface {
+	Similarity(query, vector *[256]float32) float32
+	// Distance is true if the scores are negated distances or divergences
+	Distance() bool
+}
+
+// Metrics are the metrics by name
+var Metrics = map[string]Metric{
+	"cosine":    Cosine{},
+	"dot":       Dot{},
+	"l2":        L2{},
+	"js":        JS{},
+	"kl":        KL{},
+	"hellinger": Hellinger{},
+}
+
+// Epsilon smooths the distributions compared by the divergences so they are never zero
+const Epsilon = 1e-6
+
+// similar is part of the metrics that are similarities
+type similar struct{}
+
+// Distance is false for similarities
+func (similar) Distance() bool { return false }
+
+// distant is part of the metrics that are negated distances or divergences
+type distant struct{}
+
+// Distance is true for distances and divergences
+func (distant) Distance() bool { return true }
+
+// Cosine is cosine similarity
+type Cosine struct{ similar }
+
+// Similarity is the cosine of the angle between the query and the vector
+func (Cosine) Similarity(query, vector *[256]float32) float32 {
+	return cs(dot(query[:], vector[:]), dot(query[:], query[:]), dot(vector[:], vector[:]))
+}
+
+// Dot is the dot product
+type Dot struct{ similar }
+
+// Similarity is the dot product of the query and the vector
+func (Dot) Similarity(query, vector *[256]float32) float32 {
+	return dot(query[:], vector[:])
+}
+
+// L2 is the euclidean distance
+type L2 struct{ distant }
+
+// Similarity is the negated euclidean distance between the query and the vector
+func (L2) Similarity(query, vector *[256]float32) float32 {
+	sum := float32(0.0)
+	for i, a := range query {
+		d := a - vector[i]
+		sum += d * d
+	}
+	return -float32(math.Sqrt(float64(sum)))
+}
+
+// Distribution normalizes a vector into a distribution, treating negative values as zero
+func Distribution(vector *[256]float32) (distribution [256]float32) {
+	sum := float32(0.0)
+	for i, v := range vector {
+		distribution[i] = max(v, 0) + Epsilon
+		sum += distribution[i]
+	}
+	for i := range distribution {
+		distribution[i] /= sum
+	}
+	return distribution
+}
+
+// kl is the kullback leibler divergence of two distributions
+func kl(p, q *[256]float32) float32 {
+	sum := 0.0
+	for i, a := range p {
+		sum += float64(a) * math.Log(float64(a)/float64(q[i]))
+	}
+	return float32(sum)
+}
+
+// KL is the kullback leibler divergence
+type KL struct{ distant }
+
+// Similarity is the negated divergence of the vector from the query
+func (KL) Similarity(query, vector *[256]float32) float32 {
+	p, q := Distribution(query), Distribution(vector)
+	return -kl(&p, &q)
+}
+
+// JS is the jensen shannon divergence
+type JS struct{ distant }
+
+// Similarity is the negated jensen shannon divergence of the query and the vector
+func (JS) Similarity(query, vector *[256]float32) float32 {
+	p, q := Distribution(query), Distribution(vector)
+	var m [256]float32
+	for i := range m {
+		m[i] = (p[i] + q[i]) / 2
+	}
+	return -(kl(&p, &m) + kl(&q, &m)) / 2
+}
+
+// Hellinger is the hellinger distance
+type Hellinger struct{ distant }
+
+// Similarity is the negated hellinger distance between the query and the vector
+func (Hellinger) Similarity(query, vector *[256]float32) float32 {
+	p, q := Distribution(query), Distribution(vector)
+	bc := 0.0
+	for i, a := range p {
+		bc += math.Sqrt(float64(a) * float64(q[i]))
+	}
+	return -float32(math.Sqrt(math.Max(1-bc, 0)))
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"math"
 	"math/rand"
@@ -112,9 +113,58 @@ func SumRows(k tf32.Continuation, node int, a *tf32.V, options ...map[string]int
 	return false
 }
 
+// Adam updates the weights of the set that match with the adam optimizer at step i,
+// scaling the gradient of the matching weights down to a norm of at most 1
+func Adam(set *tf32.Set, i int, eta float32, match func(name string) bool) {
+	pow := func(x float32) float32 {
+		y := math.Pow(float64(x), float64(i+1))
+		if math.IsNaN(y) || math.IsInf(y, 0) {
+			return 0
+		}
+		return float32(y)
+	}
+	norm := float32(0.0)
+	for _, p := range set.Weights {
+		if !match(p.N) {
+			continue
+		}
+		for _, d := range p.D {
+			norm += d * d
+		}
+	}
+	norm = float32(math.Sqrt(float64(norm)))
+	b1, b2 := pow(B1), pow(B2)
+	scaling := float32(1.0)
+	if norm > 1 {
+		scaling = 1 / norm
+	}
+	for _, w := range set.Weights {
+		if !match(w.N) {
+			continue
+		}
+		for l, d := range w.D {
+			g := d * scaling
+			m := B1*w.States[StateM][l] + (1-B1)*g
+			v := B2*w.States[StateV][l] + (1-B2)*g*g
+			w.States[StateM][l] = m
+			w.States[StateV][l] = v
+			mhat := m / (1 - b1)
+			vhat := v / (1 - b2)
+			if vhat < 0 {
+				vhat = 0
+			}
+			w.X[l] -= eta * mhat / (float32(math.Sqrt(float64(vhat))) + 1e-8)
+		}
+	}
+}
+
 // Learn learn a neural network
-func Learn(data []byte) Neural {
+func Learn(data []byte, specs []Spec) Neural {
 	rng := rand.New(rand.NewSource(1))
+	trained := NewMixer(specs)
+	if err := trained.Train(bytes.NewReader(data), *FlagTable); err != nil {
+		panic(err)
+	}
 	set := tf32.NewSet()
 	for i := 0; i < 256; i++ {
 		/*set.Add("query", 256, 256)
@@ -208,14 +258,6 @@ func Learn(data []byte) Neural {
 		fmt.Println("learning:", len(data))
 		i := 0
 		for in := range input {
-			pow := func(x float32) float32 {
-				y := math.Pow(float64(x), float64(i+1))
-				if math.IsNaN(y) || math.IsInf(y, 0) {
-					return 0
-				}
-				return float32(y)
-			}
-
 			others.Zero()
 			Softmax(in.Vector[:], 1.0)
 			input := others.ByName["input"].X
@@ -236,39 +278,9 @@ func Learn(data []byte) Neural {
 				break
 			}
 
-			norm := float32(0.0)
-			for _, p := range set.Weights {
-				if !strings.HasSuffix(p.N, fmt.Sprintf("_%d", prefix)) {
-					continue
-				}
-				for _, d := range p.D {
-					norm += d * d
-				}
-			}
-			norm = float32(math.Sqrt(float64(norm)))
-			b1, b2 := pow(B1), pow(B2)
-			scaling := float32(1.0)
-			if norm > 1 {
-				scaling = 1 / norm
-			}
-			for _, w := range set.Weights {
-				if !strings.HasSuffix(w.N, fmt.Sprintf("_%d", prefix)) {
-					continue
-				}
-				for l, d := range w.D {
-					g := d * scaling
-					m := B1*w.States[StateM][l] + (1-B1)*g
-					v := B2*w.States[StateV][l] + (1-B2)*g*g
-					w.States[StateM][l] = m
-					w.States[StateV][l] = v
-					mhat := m / (1 - b1)
-					vhat := v / (1 - b2)
-					if vhat < 0 {
-						vhat = 0
-					}
-					w.X[l] -= Eta * mhat / (float32(math.Sqrt(float64(vhat))) + 1e-8)
-				}
-			}
+			Adam(&set, i, Eta, func(name string) bool {
+				return strings.HasSuffix(name, fmt.Sprintf("_%d", prefix))
+			})
 			points = append(points, plotter.XY{X: float64(i), Y: float64(cost)})
 			if i%1024 == 0 {
 				fmt.Println(i, cost)
@@ -305,7 +317,7 @@ func Learn(data []byte) Neural {
 
 	for i := 0; i < 3*len(data); i++ {
 		index := rng.Intn(len(data) - 256)
-		m := NewMixer()
+		m := trained.Copy()
 		end := index + 8 + rng.Intn(120)
 		for j := index; j < end; j++ {
 			m.Add(data[j])
@@ -0,0 +1,290 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// Ranker ranks a block of records with the same markov context
+type Ranker func(txts []TXT)
+
+// Rankers returns the ranking algorithms by name, with the knn ranking linking each record to neighbors records
+func Rankers(neighbors int) map[string]Ranker {
+	return map[string]Ranker{
+		"pagerank": Rank,
+		"knn":      RankKNN(neighbors),
+		"degree":   RankDegree,
+	}
+}
+
+// Edge is a weighted edge of a sparse graph
+type Edge struct {
+	Target int
+	Weight float64
+}
+
+// PageRank computes the weighted pagerank of a dense graph of n nodes with damping alpha,
+// iterating until the ranks change by less than epsilon
+// The nodes are visited in a fixed order so the ranks are reproducible from run to run
+func PageRank(n int, weight func(i, j int) float64, alpha, epsilon float64) []float64 {
+	weights, outbound := make([]float32, n*n), make([]float64, n)
+	for i := 0; i < n; i++ {
+		row := weights[i*n : (i+1)*n]
+		for j := range row {
+			w := weight(i, j)
+			row[j] = float32(w)
+			outbound[i] += w
+		}
+		if outbound[i] > 0 {
+			for j := range row {
+				row[j] = float32(float64(row[j]) / outbound[i])
+			}
+		}
+	}
+	return iterate(n, outbound, alpha, epsilon, func(ranks, previous []float64) {
+		for i, rank := range previous {
+			a := alpha * rank
+			for j, w := range weights[i*n : (i+1)*n] {
+				ranks[j] += a * float64(w)
+			}
+		}
+	})
+}
+
+// SparsePageRank computes the weighted pagerank of a sparse graph with damping alpha,
+// iterating until the ranks change by less than epsilon
+func SparsePageRank(edges [][]Edge, alpha, epsilon float64) []float64 {
+	n, outbound := len(edges), make([]float64, len(edges))
+	for i := range edges {
+		for _, edge := range edges[i] {
+			outbound[i] += edge.Weight
+		}
+		if outbound[i] > 0 {
+			for j := range edges[i] {
+				edges[i][j].Weight /= outbound[i]
+			}
+		}
+	}
+	return iterate(n, outbound, alpha, epsilon, func(ranks, previous []float64) {
+		for i, rank := range previous {
+			a := alpha * rank
+			for _, edge := range edges[i] {
+				ranks[edge.Target] += a * edge.Weight
+			}
+		}
+	})
+}
+
+// iterate runs the pagerank power iteration, spreading the rank of nodes without outbound edges evenly
+func iterate(n int, outbound []float64, alpha, epsilon float64, spread func(ranks, previous []float64)) []float64 {
+	inverse := 1 / float64(n)
+	ranks, previous := make([]float64, n), make([]float64, n)
+	for i := range ranks {
+		ranks[i] = inverse
+	}
+	for delta := 1.0; delta > epsilon; {
+		ranks, previous = previous, ranks
+		leak := 0.0
+		for i, rank := range previous {
+			if outbound[i] == 0 {
+				leak += rank
+			}
+		}
+		leak *= alpha
+		for i := range ranks {
+			ranks[i] = (1-alpha)*inverse + leak*inverse
+		}
+		spread(ranks, previous)
+		delta = 0
+		for i := range ranks {
+			delta += math.Abs(ranks[i] - previous[i])
+		}
+	}
+	return ranks
+}
+
+// Rank ranks a block of records with pagerank over the complete cosine similarity graph
+func Rank(txts []TXT) {
+	ranks := PageRank(len(txts), func(i, j int) float64 {
+		return txts[i].CS(&txts[j].Vector)
+	}, 0.8, 1e-6)
+	for i, rank := range ranks {
+		txts[i].Rank = rank
+	}
+}
+
+// Units returns the vectors of the records scaled to unit length
+func Units(txts []TXT) [][256]float32 {
+	units := make([][256]float32, len(txts))
+	for i := range txts {
+		norm := 0.0
+		for _, v := range txts[i].Vector {
+			norm += float64(v) * float64(v)
+		}
+		if norm == 0 {
+			continue
+		}
+		norm = math.Sqrt(norm)
+		for j, v := range txts[i].Vector {
+			units[i][j] = float32(float64(v) / norm)
+		}
+	}
+	return units
+}
+
+// RankKNN ranks a block of records with pagerank over the graph linking each record
+// to its k most similar records
+// The neighbors are found by brute force, so the time is still quadratic in the block size,
+// only the memory of the graph is linear
+func RankKNN(k int) Ranker {
+	return func(txts []TXT) {
+		units := Units(txts)
+		edges := make([][]Edge, len(txts))
+		for i := range units {
+			neighbors := NewNeighbors(k)
+			for j := range units {
+				if i == j {
+					continue
+				}
+				s := dot(units[i][:], units[j][:])
+				if s > 0 && s >= neighbors.Worst() {
+					neighbors.Add(Neighbor{Index: j, Similarity: s})
+				}
+			}
+			edges[i] = make([]Edge, len(neighbors.Neighbors))
+			for j, neighbor := range neighbors.Sorted() {
+				edges[i][j] = Edge{Target: neighbor.Index, Weight: float64(neighbor.Similarity)}
+			}
+		}
+		for i, rank := range SparsePageRank(edges, 0.8, 1e-6) {
+			txts[i].Rank = rank
+		}
+	}
+}
+
+// RankDegree ranks a block of records by their total cosine similarity to the block,
+// which is the dot product of each unit vector with the sum of the unit vectors
+func RankDegree(txts []TXT) {
+	units := Units(txts)
+	sum := make([]float32, 256)
+	for i := range units {
+		for j, v := range units[i] {
+			sum[j] += v
+		}
+	}
+	total := 0.0
+	for i := range units {
+		txts[i].Rank = float64(dot(units[i][:], sum))
+		total += txts[i].Rank
+	}
+	if total > 0 {
+		for i := range txts {
+			txts[i].Rank /= total
+		}
+	}
+}
+
+// Spearman is the rank correlation of two rankings
+func Spearman(a, b []float64) float64 {
+	n := len(a)
+	if n < 2 {
+		return 1
+	}
+	positions := func(values []float64) []float64 {
+		order := make([]int, n)
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return values[order[i]] > values[order[j]]
+		})
+		position := make([]float64, n)
+		for i, o := range order {
+			position[o] = float64(i)
+		}
+		return position
+	}
+	pa, pb := positions(a), positions(b)
+	d := 0.0
+	for i := range pa {
+		diff := pa[i] - pb[i]
+		d += diff * diff
+	}
+	N := float64(n)
+	return 1 - 6*d/(N*(N*N-1))
+}
+
+// RankBench compares the ordering and speed of the rankers against pagerank on the blocks of a corpus
+func RankBench(corpus io.Reader, m Mixer, out io.Writer) error {
+	reader, cleanup, err := Prepare(corpus, m)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	markov := NewSorter(*FlagTemp, *FlagRun, ByMarkov)
+	defer markov.Close()
+	err = Vectors(reader, m, *FlagWorkers, func(txt *TXT) error {
+		return markov.Add(txt)
+	})
+	if err != nil {
+		return err
+	}
+	rankers := Rankers(*FlagNeighbors)
+	names := make([]string, 0, len(rankers))
+	for name := range rankers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	durations, correlations, weight := make(map[string]time.Duration), make(map[string]float64), 0.0
+	block := make([]TXT, 0, Block)
+	compare := func() {
+		if len(block) < 2 {
+			block = block[:0]
+			return
+		}
+		ranks := make(map[string][]float64)
+		for _, name := range names {
+			txts := make([]TXT, len(block))
+			copy(txts, block)
+			start := time.Now()
+			rankers[name](txts)
+			durations[name] += time.Since(start)
+			ranks[name] = make([]float64, len(txts))
+			for i := range txts {
+				ranks[name][i] = txts[i].Rank
+			}
+		}
+		for _, name := range names {
+			correlations[name] += float64(len(block)) * Spearman(ranks["pagerank"], ranks[name])
+		}
+		weight += float64(len(block))
+		block = block[:0]
+	}
+	err = markov.Merge(func(txt *TXT) error {
+		if len(block) > 0 && (block[0].Markov != txt.Markov || len(block) == Block) {
+			compare()
+		}
+		block = append(block, *txt)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	compare()
+	fmt.Fprintf(out, "%-10s %14s %10s\n", "ranker", "time", "spearman")
+	for _, name := range names {
+		correlation := 0.0
+		if weight > 0 {
+			correlation = correlations[name] / weight
+		}
+		fmt.Fprintf(out, "%-10s %14s %10.4f\n", name, durations[name], correlation)
+	}
+	return nil
+}
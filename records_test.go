@@ -0,0 +1,130 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// database writes a vector database of count random records encoded with the encoding into memory
+func database(t *testing.T, rng *rand.Rand, encoding Encoding, count int) ([]byte, []TXT) {
+	m := NewMixer(Specs(Windows, nil, nil, nil))
+	codec := &Codec{
+		Encoding: encoding,
+	}
+	header := NewHeader(&m, codec)
+	header.Count = uint64(count)
+	buffer := bytes.Buffer{}
+	if err := header.Write(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	writer := NewTXTWriter(&buffer, codec)
+	txts := make([]TXT, count)
+	for i := range txts {
+		txt := &txts[i]
+		for j := range txt.Vector {
+			txt.Vector[j] = rng.Float32()
+		}
+		txt.Markov = Markov{byte(rng.Intn(256)), byte(rng.Intn(256))}
+		txt.Symbol, txt.Index, txt.Rank = byte(rng.Intn(256)), uint64(i), rng.Float64()
+		if err := writer.Write(txt); err != nil {
+			t.Fatal(err)
+		}
+		encoded := make([]byte, codec.Encoding.Width())
+		codec.Encode(&txt.Vector, encoded)
+		codec.Decode(encoded, &txt.Vector)
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes(), txts
+}
+
+func TestRecords(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, encoding := range []Encoding{EncodingFloat32, EncodingInt8} {
+		data, txts := database(t, rng, encoding, 100)
+		reader, err := NewTXTReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range txts {
+			txt := TXT{}
+			if err := reader.Read(&txt); err != nil {
+				t.Fatal(err)
+			}
+			if txt != txts[i] {
+				t.Fatalf("%s: record %d was not read back", encoding, i)
+			}
+		}
+		if err := reader.Read(&TXT{}); err != io.EOF {
+			t.Fatalf("%s: %v after the last record, expected io.EOF", encoding, err)
+		}
+
+		readerAt, err := NewTXTReaderAt(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, i := range rng.Perm(len(txts)) {
+			txt := TXT{}
+			if err := readerAt.ReadAt(&txt, uint64(i)); err != nil {
+				t.Fatal(err)
+			}
+			if txt != txts[i] {
+				t.Fatalf("%s: record %d was not read back at its index", encoding, i)
+			}
+		}
+		if err := readerAt.ReadAt(&TXT{}, uint64(len(txts))); err != io.EOF {
+			t.Fatalf("%s: %v past the last record, expected io.EOF", encoding, err)
+		}
+		records := readerAt.Records()
+		for i := range txts {
+			txt := TXT{}
+			if err := records.Read(&txt); err != nil {
+				t.Fatal(err)
+			}
+			if txt != txts[i] {
+				t.Fatalf("%s: record %d was not read back in order", encoding, i)
+			}
+		}
+	}
+}
+
+func TestRecordsTruncated(t *testing.T) {
+	data, txts := database(t, rand.New(rand.NewSource(2)), EncodingInt8, 10)
+	data = data[:len(data)-7]
+	reader, err := NewTXTReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range txts[1:] {
+		if err := reader.Read(&TXT{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reader.Read(&TXT{}); err != ErrTruncated {
+		t.Fatalf("%v reading a truncated record, expected ErrTruncated", err)
+	}
+	readerAt, err := NewTXTReaderAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readerAt.ReadAt(&TXT{}, uint64(len(txts)-1)); err != ErrTruncated {
+		t.Fatalf("%v reading a truncated record at its index, expected ErrTruncated", err)
+	}
+}
+
+func TestWriteLine(t *testing.T) {
+	writer := NewTXTWriter(io.Discard, &Codec{Encoding: EncodingInt8})
+	if err := writer.WriteLine(make([]byte, EncodingInt8.Line())); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteLine(make([]byte, Line)); err == nil {
+		t.Fatal("a record of the wrong length was written")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Sampler picks the next symbol from a next symbol distribution
+type Sampler struct {
+	Rng         *rand.Rand
+	Sample      bool
+	Temperature float32
+	TopP        float32
+	Penalty     float32
+	Window      int
+	History     []byte
+}
+
+// NewSampler makes a new sampler seeded with seed
+func NewSampler(seed int64) *Sampler {
+	return &Sampler{
+		Rng:         rand.New(rand.NewSource(seed)),
+		Temperature: 1,
+		TopP:        1,
+		Penalty:     1,
+	}
+}
+
+// Next picks the next symbol and adds it to the history
+func (s *Sampler) Next(distribution [256]float32) byte {
+	if s.Penalty != 1 {
+		seen := [256]bool{}
+		for _, symbol := range s.History {
+			if !seen[symbol] {
+				seen[symbol] = true
+				distribution[symbol] /= s.Penalty
+			}
+		}
+	}
+	symbol := byte(0)
+	if !s.Sample || s.Temperature <= 0 {
+		symbol = Argmax(&distribution)
+	} else {
+		if s.Temperature != 1 {
+			for i, v := range distribution {
+				distribution[i] = float32(math.Pow(float64(v), float64(1/s.Temperature)))
+			}
+		}
+		normalize(&distribution)
+		if s.TopP > 0 && s.TopP < 1 {
+			Nucleus(&distribution, s.TopP)
+		}
+		symbol = Choose(s.Rng, &distribution)
+	}
+	s.Add(symbol)
+	return symbol
+}
+
+// Add adds a symbol to the history used for the repetition penalty
+func (s *Sampler) Add(symbol byte) {
+	if s.Window <= 0 {
+		return
+	}
+	s.History = append(s.History, symbol)
+	if len(s.History) > s.Window {
+		s.History = s.History[len(s.History)-s.Window:]
+	}
+}
+
+// Nucleus keeps the most likely symbols whose probability adds up to p
+func Nucleus(distribution *[256]float32, p float32) {
+	symbols := make([]int, 0, 256)
+	for i, v := range distribution {
+		if v > 0 {
+			symbols = append(symbols, i)
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return distribution[symbols[i]] > distribution[symbols[j]]
+	})
+	sum, keep := float32(0.0), [256]bool{}
+	for _, symbol := range symbols {
+		keep[symbol] = true
+		sum += distribution[symbol]
+		if sum >= p {
+			break
+		}
+	}
+	for i := range distribution {
+		if !keep[i] {
+			distribution[i] = 0
+		}
+	}
+	normalize(distribution)
+}
+
+func normalize(distribution *[256]float32) {
+	sum := float32(0.0)
+	for _, v := range distribution {
+		sum += v
+	}
+	if sum == 0 {
+		return
+	}
+	for i := range distribution {
+		distribution[i] /= sum
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Neighbor is a record found by a search
+type Neighbor struct {
+	Index      int
+	Similarity float32
+	Symbol     byte
+}
+
+// Better is true if the neighbor is more similar, with ties going to the earlier record
+func (n Neighbor) Better(o Neighbor) bool {
+	if n.Similarity != o.Similarity {
+		return n.Similarity > o.Similarity
+	}
+	return n.Index < o.Index
+}
+
+// Neighbors is a min heap holding the K most similar records
+type Neighbors struct {
+	K         int
+	Neighbors []Neighbor
+}
+
+// NewNeighbors makes a heap for the k most similar records
+func NewNeighbors(k int) *Neighbors {
+	if k < 1 {
+		k = 1
+	}
+	return &Neighbors{
+		K:         k,
+		Neighbors: make([]Neighbor, 0, k),
+	}
+}
+
+func (n *Neighbors) Len() int           { return len(n.Neighbors) }
+func (n *Neighbors) Less(i, j int) bool { return n.Neighbors[j].Better(n.Neighbors[i]) }
+func (n *Neighbors) Swap(i, j int)      { n.Neighbors[i], n.Neighbors[j] = n.Neighbors[j], n.Neighbors[i] }
+func (n *Neighbors) Push(x any)         { n.Neighbors = append(n.Neighbors, x.(Neighbor)) }
+func (n *Neighbors) Pop() any {
+	last := n.Neighbors[len(n.Neighbors)-1]
+	n.Neighbors = n.Neighbors[:len(n.Neighbors)-1]
+	return last
+}
+
+// Add adds a neighbor if it is one of the K most similar
+func (n *Neighbors) Add(neighbor Neighbor) {
+	if len(n.Neighbors) < n.K {
+		heap.Push(n, neighbor)
+	} else if neighbor.Better(n.Neighbors[0]) {
+		n.Neighbors[0] = neighbor
+		heap.Fix(n, 0)
+	}
+}
+
+// Worst is the similarity a record has to beat to be added
+func (n *Neighbors) Worst() float32 {
+	if len(n.Neighbors) < n.K {
+		return float32(math.Inf(-1))
+	}
+	return n.Neighbors[0].Similarity
+}
+
+// Merge adds the neighbors of another heap
+func (n *Neighbors) Merge(o *Neighbors) {
+	for _, neighbor := range o.Neighbors {
+		n.Add(neighbor)
+	}
+}
+
+// Sorted returns the neighbors from most to least similar
+func (n *Neighbors) Sorted() []Neighbor {
+	sorted := make([]Neighbor, len(n.Neighbors))
+	copy(sorted, n.Neighbors)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Better(sorted[j])
+	})
+	return sorted
+}
+
+// Score combines the similarity of a record with its relative rank using a weight
+type Score func(similarity float32, rank, weight float64) float32
+
+// Scores are the scoring formulas by name
+// The product multiplies a positive similarity by the rank to the weight and divides a negative one,
+// such as a negated distance, by it, so with every metric a higher rank scores higher
+var Scores = map[string]Score{
+	"similarity": func(similarity float32, rank, weight float64) float32 {
+		return similarity
+	},
+	"product": func(similarity float32, rank, weight float64) float32 {
+		prior := float32(math.Pow(rank, weight))
+		if similarity < 0 {
+			return similarity / prior
+		}
+		return similarity * prior
+	},
+	"sum": func(similarity float32, rank, weight float64) float32 {
+		if rank <= 0 {
+			return float32(math.Inf(-1))
+		}
+		return similarity + float32(weight*math.Log(rank))
+	},
+}
+
+// Query is a search for the K records that score highest against a vector with a metric, which defaults to cosine
+// Similarity compares the vector with the encoded vectors of the records, and is prepared by the first search
+// With LeaveOut the record made at Position in the corpus is skipped, for evaluating on the corpus of the database
+type Query struct {
+	Vector     *[256]float32
+	K          int
+	Score      Score
+	Weight     float64
+	Metric     Metric
+	LeaveOut   bool
+	Position   uint64
+	Similarity func(encoded []byte, scratch *[256]float32) float32
+}
+
+// Prepare prepares the similarity of the query for the metric and the encoding of the records
+func (d *DB) Prepare(q *Query) {
+	if q.Metric == nil {
+		q.Metric = Cosine{}
+	}
+	if q.Similarity == nil {
+		q.Similarity = d.Header.Codec().Similarity(q.Metric, q.Vector)
+	}
+}
+
+// Search scans the records from start to end for the K highest scoring records
+func (d *DB) Search(q *Query, start, end int) *Neighbors {
+	d.Prepare(q)
+	neighbors, scratch := NewNeighbors(q.K), [256]float32{}
+	for i := start; i < end; i++ {
+		record := d.Record(i)
+		if q.LeaveOut && record.Index() == q.Position {
+			continue
+		}
+		s := q.Similarity(record.Vector(), &scratch)
+		if q.Score != nil {
+			s = q.Score(s, record.Rank(), q.Weight)
+		}
+		if s >= neighbors.Worst() {
+			neighbors.Add(Neighbor{Index: i, Similarity: s, Symbol: record.Symbol()})
+		}
+	}
+	return neighbors
+}
+
+// ParallelSearch shards the records from start to end across workers and merges the K highest scoring of each
+func (d *DB) ParallelSearch(q *Query, start, end, workers int) *Neighbors {
+	if workers < 1 {
+		workers = 1
+	}
+	d.Prepare(q)
+	size := (end - start + workers - 1) / workers
+	if workers == 1 || size < 1024 {
+		return d.Search(q, start, end)
+	}
+	results := make([]*Neighbors, workers)
+	var wg sync.WaitGroup
+	for i := range results {
+		begin := start + i*size
+		finish := min(begin+size, end)
+		if begin >= finish {
+			results[i] = NewNeighbors(q.K)
+			continue
+		}
+		wg.Add(1)
+		go func(i, begin, finish int) {
+			defer wg.Done()
+			results[i] = d.Search(q, begin, finish)
+		}(i, begin, finish)
+	}
+	wg.Wait()
+	neighbors := results[0]
+	for _, result := range results[1:] {
+		neighbors.Merge(result)
+	}
+	return neighbors
+}
+
+// Range is a range of records from Start up to End
+type Range struct {
+	Start int
+	End   int
+}
+
+// Len is the number of records in the range
+func (r Range) Len() int {
+	return r.End - r.Start
+}
+
+// Window returns the window highest ranked records with the markov context, or all of them if window is 0
+// The records of a markov context larger than a block are sorted by ranks relative to their blocks,
+// so the window takes the highest ranked records of every block rather than the records of one block
+func (d *DB) Window(m Markov, window int) Range {
+	start, end := d.Header.Bucket(m)
+	r := Range{Start: int(start), End: int(end)}
+	if window > 0 && r.Len() > window {
+		r.End = r.Start + window
+	}
+	return r
+}
+
+// Windows returns the windows of the markov contexts from first to last
+func (d *DB) Windows(first, last Markov, window int) []Range {
+	if window == 0 {
+		start, _ := d.Header.Bucket(first)
+		_, end := d.Header.Bucket(last)
+		return []Range{{Start: int(start), End: int(end)}}
+	}
+	ranges := make([]Range, 0, 256)
+	for key := first.Key(); key <= last.Key(); key++ {
+		r := d.Window(Markov{byte(key >> 8), byte(key)}, window)
+		if r.Len() > 0 {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// MaxOrder is the longest context the records are sorted by
+const MaxOrder = len(Markov{})
+
+// Context returns the windows of the records that share the last order symbols of the markov context
+func (d *DB) Context(m Markov, order, window int) []Range {
+	switch order {
+	case 2:
+		if r := d.Window(m, window); r.Len() > 0 {
+			return []Range{r}
+		}
+		return nil
+	case 1:
+		return d.Windows(Markov{m[0], 0}, Markov{m[0], 255}, window)
+	}
+	return d.Windows(Markov{0, 0}, Markov{255, 255}, window)
+}
+
+// Backoff returns the records to scan for the markov context and the order of the context they share
+// Like PPM, the longest context up to order with at least min records is used, backing off to shorter ones
+func (d *DB) Backoff(m Markov, order, window, min int) ([]Range, int) {
+	order = max(0, order)
+	for ; order > 0; order-- {
+		ranges, count := d.Context(m, order, window), 0
+		for _, r := range ranges {
+			count += r.Len()
+		}
+		if count >= min && count > 0 {
+			return ranges, order
+		}
+	}
+	return d.Context(m, 0, window), 0
+}
+
+// SearchRanges searches the ranges of records for the K highest scoring records
+func (d *DB) SearchRanges(q *Query, ranges []Range, workers int) *Neighbors {
+	neighbors := NewNeighbors(q.K)
+	for _, r := range ranges {
+		neighbors.Merge(d.ParallelSearch(q, r.Start, r.End, workers))
+	}
+	return neighbors
+}
+
+// Vote forms a next symbol distribution from the similarity weighted votes of the neighbors found with a metric
+// With a temperature T > 0 each vote is weighted by exp(similarity/T)
+// Without one a similarity weights its vote by itself, while the temperature of negated distances defaults to
+// the average distance of the neighbors from the nearest one, so every neighbor votes whatever the scale of the distances
+func Vote(neighbors []Neighbor, metric Metric, T float32) (distribution [256]float32) {
+	if len(neighbors) == 0 {
+		return distribution
+	}
+	max := float32(math.Inf(-1))
+	for _, neighbor := range neighbors {
+		if neighbor.Similarity > max {
+			max = neighbor.Similarity
+		}
+	}
+	if T <= 0 && metric.Distance() {
+		T = 0
+		for _, neighbor := range neighbors {
+			T += max - neighbor.Similarity
+		}
+		T /= float32(len(neighbors))
+	}
+	sum := float32(0.0)
+	for _, neighbor := range neighbors {
+		weight := neighbor.Similarity
+		if T > 0 {
+			weight = float32(math.Exp(float64((neighbor.Similarity - max) / T)))
+		}
+		if weight < 0 || math.IsNaN(float64(weight)) {
+			weight = 0
+		}
+		distribution[neighbor.Symbol] += weight
+		sum += weight
+	}
+	if sum == 0 {
+		for _, neighbor := range neighbors {
+			distribution[neighbor.Symbol]++
+		}
+		sum = float32(len(neighbors))
+	}
+	for i := range distribution {
+		distribution[i] /= sum
+	}
+	return distribution
+}
+
+// Argmax returns the most likely symbol, with ties going to the smaller symbol
+func Argmax(distribution *[256]float32) byte {
+	symbol, max := 0, float32(-1.0)
+	for i, v := range distribution {
+		if v > max {
+			symbol, max = i, v
+		}
+	}
+	return byte(symbol)
+}
+
+// Choose draws a symbol from the distribution
+func Choose(rng *rand.Rand, distribution *[256]float32) byte {
+	selection, sum := rng.Float32(), float32(0.0)
+	last := 0
+	for i, v := range distribution {
+		if v == 0 {
+			continue
+		}
+		sum += v
+		last = i
+		if selection < sum {
+			return byte(i)
+		}
+	}
+	return byte(last)
+}
@@ -0,0 +1,53 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestVoteSimilarity(t *testing.T) {
+	neighbors := []Neighbor{{Similarity: 0.75, Symbol: 'a'}, {Similarity: 0.25, Symbol: 'b'}, {Similarity: -0.5, Symbol: 'c'}}
+	distribution := Vote(neighbors, Cosine{}, 0)
+	if distribution['a'] != 0.75 || distribution['b'] != 0.25 || distribution['c'] != 0 {
+		t.Fatalf("similarities didn't weight the votes: %g %g %g", distribution['a'], distribution['b'], distribution['c'])
+	}
+}
+
+func TestVoteDistance(t *testing.T) {
+	for _, metric := range []Metric{L2{}, KL{}, JS{}, Hellinger{}} {
+		for _, scale := range []float32{1e-4, 1, 1e4} {
+			for _, shift := range []float32{0, 10} {
+				neighbors := []Neighbor{
+					{Similarity: shift - 1*scale, Symbol: 'a'},
+					{Similarity: shift - 2*scale, Symbol: 'b'},
+					{Similarity: shift - 3*scale, Symbol: 'c'},
+				}
+				distribution := Vote(neighbors, metric, 0)
+				a, b, c := distribution['a'], distribution['b'], distribution['c']
+				if !(a > b && b > c && c > 0) {
+					t.Fatalf("every neighbor should vote, nearest first: %g %g %g", a, b, c)
+				}
+				if expected := float32(math.Exp(-1)); math.Abs(float64(b/a-expected)) > 1e-3 {
+					t.Fatalf("the votes depend on the scale %g or shift %g of the distances: %g", scale, shift, b/a)
+				}
+			}
+		}
+		neighbors := []Neighbor{{Similarity: -1, Symbol: 'a'}, {Similarity: -1, Symbol: 'b'}}
+		if distribution := Vote(neighbors, metric, 0); distribution['a'] != 0.5 || distribution['b'] != 0.5 {
+			t.Fatalf("equally distant neighbors should vote equally: %g %g", distribution['a'], distribution['b'])
+		}
+	}
+}
+
+func TestScoreProduct(t *testing.T) {
+	product := Scores["product"]
+	for _, similarity := range []float32{-2, -0.5, 0.5, 2} {
+		if low, high := product(similarity, 0.5, 1), product(similarity, 2, 1); !(high > low) {
+			t.Fatalf("a higher rank scored lower with similarity %g: %g <= %g", similarity, high, low)
+		}
+	}
+}
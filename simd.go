@@ -0,0 +1,114 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Kernels are the vector kernels of the matrix products and the similarities
+type Kernels struct {
+	Name string
+	// Dot is the dot product of two float32 vectors
+	Dot func(x, y []float32) float32
+	// DotNormFloat32 is the dot product of x with big endian float32s and their squared norm
+	DotNormFloat32 func(x []float32, y []byte) (xy, yy float32)
+	// DotNormUint8 is the dot product of x with unsigned bytes and their squared norm
+	DotNormUint8 func(x []float32, y []byte) (xy, yy float32)
+	// DotNormInt8 is the dot product of x with signed bytes and their squared norm
+	DotNormInt8 func(x []float32, y []byte) (xy, yy float32)
+}
+
+// Generic are the pure Go kernels
+var Generic = Kernels{
+	Name:           "generic",
+	Dot:            dotGeneric,
+	DotNormFloat32: dotNormFloat32Generic,
+	DotNormUint8:   dotNormUint8Generic,
+	DotNormInt8:    dotNormInt8Generic,
+}
+
+// Supported are the SIMD kernels the cpu supports, from the slowest to the fastest
+var Supported []Kernels
+
+// Accelerated are the fastest kernels the cpu supports, which are selected when the program starts
+var Accelerated = Generic
+
+func dotGeneric(x, y []float32) (z float32) {
+	for i := range x {
+		z += x[i] * y[i]
+	}
+	return z
+}
+
+func dotNormFloat32Generic(x []float32, y []byte) (xy, yy float32) {
+	for i, a := range x {
+		b := math.Float32frombits(binary.BigEndian.Uint32(y[4*i:]))
+		xy += a * b
+		yy += b * b
+	}
+	return xy, yy
+}
+
+func dotNormUint8Generic(x []float32, y []byte) (xy, yy float32) {
+	for i, a := range x {
+		b := float32(y[i])
+		xy += a * b
+		yy += b * b
+	}
+	return xy, yy
+}
+
+func dotNormInt8Generic(x []float32, y []byte) (xy, yy float32) {
+	for i, a := range x {
+		b := float32(int8(y[i]))
+		xy += a * b
+		yy += b * b
+	}
+	return xy, yy
+}
+
+// SIMDBench times the generic and the accelerated kernels on vectors the size of the embeddings
+// and prints how much faster the accelerated kernels are and how far their results are from the generic ones
+func SIMDBench(iterations int, out io.Writer) {
+	rng := rand.New(rand.NewSource(1))
+	x, y, encoded, quantized := make([]float32, 256), make([]float32, 256), make([]byte, 4*256), make([]byte, 256)
+	for i := range x {
+		x[i], y[i] = rng.Float32(), rng.Float32()
+		binary.BigEndian.PutUint32(encoded[4*i:], math.Float32bits(y[i]))
+		quantized[i] = byte(rng.Intn(256))
+	}
+	benchmarks := []struct {
+		Name   string
+		Kernel func(k *Kernels) (float32, float32)
+	}{
+		{"dot", func(k *Kernels) (float32, float32) { return k.Dot(x, y), 0 }},
+		{"float32", func(k *Kernels) (float32, float32) { return k.DotNormFloat32(x, encoded) }},
+		{"uint8", func(k *Kernels) (float32, float32) { return k.DotNormUint8(x, quantized) }},
+		{"int8", func(k *Kernels) (float32, float32) { return k.DotNormInt8(x, quantized) }},
+	}
+	measure := func(k *Kernels, kernel func(k *Kernels) (float32, float32)) (time.Duration, float32, float32) {
+		start := time.Now()
+		a, b := kernel(k)
+		for i := 1; i < iterations; i++ {
+			kernel(k)
+		}
+		return time.Since(start) / time.Duration(max(iterations, 1)), a, b
+	}
+	fmt.Fprintf(out, "kernels: %s\n", Accelerated.Name)
+	fmt.Fprintf(out, "%-10s %12s %12s %10s %12s\n", "kernel", "generic", Accelerated.Name, "speedup", "error")
+	for _, benchmark := range benchmarks {
+		generic, a, b := measure(&Generic, benchmark.Kernel)
+		accelerated, c, d := measure(&Accelerated, benchmark.Kernel)
+		difference := max(math.Abs(float64(c-a))/math.Abs(float64(a)), math.Abs(float64(d-b))/max(math.Abs(float64(b)), 1))
+		fmt.Fprintf(out, "%-10s %12s %12s %10.2f %12.3g\n", benchmark.Name, generic, accelerated,
+			float64(generic)/float64(max(accelerated, 1)), difference)
+	}
+}
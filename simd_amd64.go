@@ -0,0 +1,92 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// cpuid executes the cpuid instruction for a leaf and subleaf
+func cpuid(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32)
+
+// xgetbv reads the extended control register of the vector registers the os saves
+func xgetbv() (eax, edx uint32)
+
+//go:noescape
+func dotAVX2(x, y []float32) float32
+
+//go:noescape
+func dotNormFloat32AVX2(x []float32, y []byte) (xy, yy float32)
+
+//go:noescape
+func dotNormUint8AVX2(x []float32, y []byte) (xy, yy float32)
+
+//go:noescape
+func dotNormInt8AVX2(x []float32, y []byte) (xy, yy float32)
+
+//go:noescape
+func dotAVX512(x, y []float32) float32
+
+//go:noescape
+func dotNormFloat32AVX512(x []float32, y []byte) (xy, yy float32)
+
+//go:noescape
+func dotNormUint8AVX512(x []float32, y []byte) (xy, yy float32)
+
+//go:noescape
+func dotNormInt8AVX512(x []float32, y []byte) (xy, yy float32)
+
+// HasAVX2 is true if the cpu supports AVX2 and FMA and the os saves the ymm registers
+func HasAVX2() bool {
+	leaves, _, _, _ := cpuid(0, 0)
+	if leaves < 7 {
+		return false
+	}
+	_, _, ecx, _ := cpuid(1, 0)
+	const fma, osxsave, avx = 1 << 12, 1 << 27, 1 << 28
+	if ecx&fma == 0 || ecx&osxsave == 0 || ecx&avx == 0 {
+		return false
+	}
+	if xcr0, _ := xgetbv(); xcr0&6 != 6 {
+		return false
+	}
+	_, ebx, _, _ := cpuid(7, 0)
+	const avx2 = 1 << 5
+	return ebx&avx2 != 0
+}
+
+// HasAVX512 is true if the cpu supports AVX2 and FMA, AVX-512 foundation and byte and word instructions,
+// and the os saves the zmm registers
+func HasAVX512() bool {
+	if !HasAVX2() {
+		return false
+	}
+	if xcr0, _ := xgetbv(); xcr0&0xe6 != 0xe6 {
+		return false
+	}
+	_, ebx, _, _ := cpuid(7, 0)
+	const avx512f, avx512bw = 1 << 16, 1 << 30
+	return ebx&avx512f != 0 && ebx&avx512bw != 0
+}
+
+func init() {
+	if HasAVX2() {
+		Supported = append(Supported, Kernels{
+			Name:           "avx2",
+			Dot:            simdDot(dotAVX2),
+			DotNormFloat32: simdDotNorm(dotNormFloat32AVX2, dotNormFloat32Generic, 4),
+			DotNormUint8:   simdDotNorm(dotNormUint8AVX2, dotNormUint8Generic, 1),
+			DotNormInt8:    simdDotNorm(dotNormInt8AVX2, dotNormInt8Generic, 1),
+		})
+	}
+	if HasAVX512() {
+		Supported = append(Supported, Kernels{
+			Name:           "avx512",
+			Dot:            simdDot(dotAVX512),
+			DotNormFloat32: simdDotNorm(dotNormFloat32AVX512, dotNormFloat32Generic, 4),
+			DotNormUint8:   simdDotNorm(dotNormUint8AVX512, dotNormUint8Generic, 1),
+			DotNormInt8:    simdDotNorm(dotNormInt8AVX512, dotNormInt8Generic, 1),
+		})
+	}
+	if len(Supported) > 0 {
+		Accelerated = Supported[len(Supported)-1]
+	}
+}
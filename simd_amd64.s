@@ -0,0 +1,386 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// REDUCE sums the eight lanes of a ymm register into the low lane of its xmm register
+#define REDUCE(Y, X, T) \
+	VEXTRACTF128 $1, Y, T; \
+	VADDPS       T, X, X;  \
+	VHADDPS      X, X, X;  \
+	VHADDPS      X, X, X
+
+// REDUCE512 sums the sixteen lanes of a zmm register into the low lane of its xmm register
+#define REDUCE512(Z, Y, X, TY, TX) \
+	VEXTRACTF64X4 $1, Z, TY; \
+	VADDPS        TY, Y, Y;  \
+	REDUCE(Y, X, TX)
+
+// bswap32 reverses the bytes of each 32 bit lane, the AVX2 kernels use the first 32 bytes
+DATA bswap32<>+0(SB)/8, $0x0405060700010203
+DATA bswap32<>+8(SB)/8, $0x0c0d0e0f08090a0b
+DATA bswap32<>+16(SB)/8, $0x0405060700010203
+DATA bswap32<>+24(SB)/8, $0x0c0d0e0f08090a0b
+DATA bswap32<>+32(SB)/8, $0x0405060700010203
+DATA bswap32<>+40(SB)/8, $0x0c0d0e0f08090a0b
+DATA bswap32<>+48(SB)/8, $0x0405060700010203
+DATA bswap32<>+56(SB)/8, $0x0c0d0e0f08090a0b
+GLOBL bswap32<>(SB), RODATA|NOPTR, $64
+
+// func cpuid(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL leaf+0(FP), AX
+	MOVL subleaf+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
+
+// func dotAVX2(x, y []float32) float32
+// The length of x is a multiple of 16
+TEXT ·dotAVX2(SB), NOSPLIT, $0-52
+	MOVQ   x_base+0(FP), SI
+	MOVQ   x_len+8(FP), CX
+	MOVQ   y_base+24(FP), DI
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+	VXORPS Y2, Y2, Y2
+	VXORPS Y3, Y3, Y3
+
+loop32:
+	CMPQ        CX, $32
+	JL          tail
+	VMOVUPS     (SI), Y4
+	VMOVUPS     32(SI), Y5
+	VMOVUPS     64(SI), Y6
+	VMOVUPS     96(SI), Y7
+	VFMADD231PS (DI), Y4, Y0
+	VFMADD231PS 32(DI), Y5, Y1
+	VFMADD231PS 64(DI), Y6, Y2
+	VFMADD231PS 96(DI), Y7, Y3
+	ADDQ        $128, SI
+	ADDQ        $128, DI
+	SUBQ        $32, CX
+	JMP         loop32
+
+tail:
+	CMPQ        CX, $16
+	JL          done
+	VMOVUPS     (SI), Y4
+	VMOVUPS     32(SI), Y5
+	VFMADD231PS (DI), Y4, Y0
+	VFMADD231PS 32(DI), Y5, Y1
+
+done:
+	VADDPS Y1, Y0, Y0
+	VADDPS Y3, Y2, Y2
+	VADDPS Y2, Y0, Y0
+	REDUCE(Y0, X0, X1)
+	VZEROUPPER
+	MOVSS  X0, ret+48(FP)
+	RET
+
+// func dotNormFloat32AVX2(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many big endian float32s
+TEXT ·dotNormFloat32AVX2(SB), NOSPLIT, $0-56
+	MOVQ    x_base+0(FP), SI
+	MOVQ    x_len+8(FP), CX
+	MOVQ    y_base+24(FP), DI
+	VMOVDQU bswap32<>(SB), Y8
+	VXORPS  Y0, Y0, Y0
+	VXORPS  Y1, Y1, Y1
+	VXORPS  Y2, Y2, Y2
+	VXORPS  Y3, Y3, Y3
+	TESTQ   CX, CX
+	JZ      done
+
+loop:
+	VMOVDQU     (DI), Y4
+	VMOVDQU     32(DI), Y5
+	VPSHUFB     Y8, Y4, Y4
+	VPSHUFB     Y8, Y5, Y5
+	VFMADD231PS (SI), Y4, Y0
+	VFMADD231PS 32(SI), Y5, Y1
+	VFMADD231PS Y4, Y4, Y2
+	VFMADD231PS Y5, Y5, Y3
+	ADDQ        $64, SI
+	ADDQ        $64, DI
+	SUBQ        $16, CX
+	JNZ         loop
+
+done:
+	VADDPS Y1, Y0, Y0
+	VADDPS Y3, Y2, Y2
+	REDUCE(Y0, X0, X1)
+	REDUCE(Y2, X2, X3)
+	VZEROUPPER
+	MOVSS  X0, xy+48(FP)
+	MOVSS  X2, yy+52(FP)
+	RET
+
+// func dotNormUint8AVX2(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many bytes
+TEXT ·dotNormUint8AVX2(SB), NOSPLIT, $0-56
+	MOVQ   x_base+0(FP), SI
+	MOVQ   x_len+8(FP), CX
+	MOVQ   y_base+24(FP), DI
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+	VXORPS Y2, Y2, Y2
+	VXORPS Y3, Y3, Y3
+	TESTQ  CX, CX
+	JZ     done
+
+loop:
+	VPMOVZXBD   (DI), Y4
+	VPMOVZXBD   8(DI), Y5
+	VCVTDQ2PS   Y4, Y4
+	VCVTDQ2PS   Y5, Y5
+	VFMADD231PS (SI), Y4, Y0
+	VFMADD231PS 32(SI), Y5, Y1
+	VFMADD231PS Y4, Y4, Y2
+	VFMADD231PS Y5, Y5, Y3
+	ADDQ        $64, SI
+	ADDQ        $16, DI
+	SUBQ        $16, CX
+	JNZ         loop
+
+done:
+	VADDPS Y1, Y0, Y0
+	VADDPS Y3, Y2, Y2
+	REDUCE(Y0, X0, X1)
+	REDUCE(Y2, X2, X3)
+	VZEROUPPER
+	MOVSS  X0, xy+48(FP)
+	MOVSS  X2, yy+52(FP)
+	RET
+
+// func dotNormInt8AVX2(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many bytes
+TEXT ·dotNormInt8AVX2(SB), NOSPLIT, $0-56
+	MOVQ   x_base+0(FP), SI
+	MOVQ   x_len+8(FP), CX
+	MOVQ   y_base+24(FP), DI
+	VXORPS Y0, Y0, Y0
+	VXORPS Y1, Y1, Y1
+	VXORPS Y2, Y2, Y2
+	VXORPS Y3, Y3, Y3
+	TESTQ  CX, CX
+	JZ     done
+
+loop:
+	VPMOVSXBD   (DI), Y4
+	VPMOVSXBD   8(DI), Y5
+	VCVTDQ2PS   Y4, Y4
+	VCVTDQ2PS   Y5, Y5
+	VFMADD231PS (SI), Y4, Y0
+	VFMADD231PS 32(SI), Y5, Y1
+	VFMADD231PS Y4, Y4, Y2
+	VFMADD231PS Y5, Y5, Y3
+	ADDQ        $64, SI
+	ADDQ        $16, DI
+	SUBQ        $16, CX
+	JNZ         loop
+
+done:
+	VADDPS Y1, Y0, Y0
+	VADDPS Y3, Y2, Y2
+	REDUCE(Y0, X0, X1)
+	REDUCE(Y2, X2, X3)
+	VZEROUPPER
+	MOVSS  X0, xy+48(FP)
+	MOVSS  X2, yy+52(FP)
+	RET
+
+// func dotAVX512(x, y []float32) float32
+// The length of x is a multiple of 16
+TEXT ·dotAVX512(SB), NOSPLIT, $0-52
+	MOVQ   x_base+0(FP), SI
+	MOVQ   x_len+8(FP), CX
+	MOVQ   y_base+24(FP), DI
+	VPXORD Z0, Z0, Z0
+	VPXORD Z1, Z1, Z1
+	VPXORD Z2, Z2, Z2
+	VPXORD Z3, Z3, Z3
+
+loop64:
+	CMPQ        CX, $64
+	JL          tail
+	VMOVUPS     (SI), Z4
+	VMOVUPS     64(SI), Z5
+	VMOVUPS     128(SI), Z6
+	VMOVUPS     192(SI), Z7
+	VFMADD231PS (DI), Z4, Z0
+	VFMADD231PS 64(DI), Z5, Z1
+	VFMADD231PS 128(DI), Z6, Z2
+	VFMADD231PS 192(DI), Z7, Z3
+	ADDQ        $256, SI
+	ADDQ        $256, DI
+	SUBQ        $64, CX
+	JMP         loop64
+
+tail:
+	CMPQ        CX, $16
+	JL          done
+	VMOVUPS     (SI), Z4
+	VFMADD231PS (DI), Z4, Z0
+	ADDQ        $64, SI
+	ADDQ        $64, DI
+	SUBQ        $16, CX
+	JMP         tail
+
+done:
+	VADDPS Z1, Z0, Z0
+	VADDPS Z3, Z2, Z2
+	VADDPS Z2, Z0, Z0
+	REDUCE512(Z0, Y0, X0, Y1, X1)
+	VZEROUPPER
+	MOVSS  X0, ret+48(FP)
+	RET
+
+// func dotNormFloat32AVX512(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many big endian float32s
+TEXT ·dotNormFloat32AVX512(SB), NOSPLIT, $0-56
+	MOVQ      x_base+0(FP), SI
+	MOVQ      x_len+8(FP), CX
+	MOVQ      y_base+24(FP), DI
+	VMOVDQU32 bswap32<>(SB), Z8
+	VPXORD    Z0, Z0, Z0
+	VPXORD    Z1, Z1, Z1
+	VPXORD    Z2, Z2, Z2
+	VPXORD    Z3, Z3, Z3
+
+loop32:
+	CMPQ        CX, $32
+	JL          tail
+	VMOVDQU32   (DI), Z4
+	VMOVDQU32   64(DI), Z5
+	VPSHUFB     Z8, Z4, Z4
+	VPSHUFB     Z8, Z5, Z5
+	VFMADD231PS (SI), Z4, Z0
+	VFMADD231PS 64(SI), Z5, Z1
+	VFMADD231PS Z4, Z4, Z2
+	VFMADD231PS Z5, Z5, Z3
+	ADDQ        $128, SI
+	ADDQ        $128, DI
+	SUBQ        $32, CX
+	JMP         loop32
+
+tail:
+	CMPQ        CX, $16
+	JL          done
+	VMOVDQU32   (DI), Z4
+	VPSHUFB     Z8, Z4, Z4
+	VFMADD231PS (SI), Z4, Z0
+	VFMADD231PS Z4, Z4, Z2
+
+done:
+	VADDPS Z1, Z0, Z0
+	VADDPS Z3, Z2, Z2
+	REDUCE512(Z0, Y0, X0, Y1, X1)
+	REDUCE512(Z2, Y2, X2, Y3, X3)
+	VZEROUPPER
+	MOVSS  X0, xy+48(FP)
+	MOVSS  X2, yy+52(FP)
+	RET
+
+// func dotNormUint8AVX512(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many bytes
+TEXT ·dotNormUint8AVX512(SB), NOSPLIT, $0-56
+	MOVQ   x_base+0(FP), SI
+	MOVQ   x_len+8(FP), CX
+	MOVQ   y_base+24(FP), DI
+	VPXORD Z0, Z0, Z0
+	VPXORD Z1, Z1, Z1
+	VPXORD Z2, Z2, Z2
+	VPXORD Z3, Z3, Z3
+
+loop32:
+	CMPQ        CX, $32
+	JL          tail
+	VPMOVZXBD   (DI), Z4
+	VPMOVZXBD   16(DI), Z5
+	VCVTDQ2PS   Z4, Z4
+	VCVTDQ2PS   Z5, Z5
+	VFMADD231PS (SI), Z4, Z0
+	VFMADD231PS 64(SI), Z5, Z1
+	VFMADD231PS Z4, Z4, Z2
+	VFMADD231PS Z5, Z5, Z3
+	ADDQ        $128, SI
+	ADDQ        $32, DI
+	SUBQ        $32, CX
+	JMP         loop32
+
+tail:
+	CMPQ        CX, $16
+	JL          done
+	VPMOVZXBD   (DI), Z4
+	VCVTDQ2PS   Z4, Z4
+	VFMADD231PS (SI), Z4, Z0
+	VFMADD231PS Z4, Z4, Z2
+
+done:
+	VADDPS Z1, Z0, Z0
+	VADDPS Z3, Z2, Z2
+	REDUCE512(Z0, Y0, X0, Y1, X1)
+	REDUCE512(Z2, Y2, X2, Y3, X3)
+	VZEROUPPER
+	MOVSS  X0, xy+48(FP)
+	MOVSS  X2, yy+52(FP)
+	RET
+
+// func dotNormInt8AVX512(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many bytes
+TEXT ·dotNormInt8AVX512(SB), NOSPLIT, $0-56
+	MOVQ   x_base+0(FP), SI
+	MOVQ   x_len+8(FP), CX
+	MOVQ   y_base+24(FP), DI
+	VPXORD Z0, Z0, Z0
+	VPXORD Z1, Z1, Z1
+	VPXORD Z2, Z2, Z2
+	VPXORD Z3, Z3, Z3
+
+loop32:
+	CMPQ        CX, $32
+	JL          tail
+	VPMOVSXBD   (DI), Z4
+	VPMOVSXBD   16(DI), Z5
+	VCVTDQ2PS   Z4, Z4
+	VCVTDQ2PS   Z5, Z5
+	VFMADD231PS (SI), Z4, Z0
+	VFMADD231PS 64(SI), Z5, Z1
+	VFMADD231PS Z4, Z4, Z2
+	VFMADD231PS Z5, Z5, Z3
+	ADDQ        $128, SI
+	ADDQ        $32, DI
+	SUBQ        $32, CX
+	JMP         loop32
+
+tail:
+	CMPQ        CX, $16
+	JL          done
+	VPMOVSXBD   (DI), Z4
+	VCVTDQ2PS   Z4, Z4
+	VFMADD231PS (SI), Z4, Z0
+	VFMADD231PS Z4, Z4, Z2
+
+done:
+	VADDPS Z1, Z0, Z0
+	VADDPS Z3, Z2, Z2
+	REDUCE512(Z0, Y0, X0, Y1, X1)
+	REDUCE512(Z2, Y2, X2, Y3, X3)
+	VZEROUPPER
+	MOVSS  X0, xy+48(FP)
+	MOVSS  X2, yy+52(FP)
+	RET
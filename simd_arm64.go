@@ -0,0 +1,29 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+//go:noescape
+func dotNEON(x, y []float32) float32
+
+//go:noescape
+func dotNormFloat32NEON(x []float32, y []byte) (xy, yy float32)
+
+//go:noescape
+func dotNormUint8NEON(x []float32, y []byte) (xy, yy float32)
+
+//go:noescape
+func dotNormInt8NEON(x []float32, y []byte) (xy, yy float32)
+
+// Advanced SIMD is part of every arm64 cpu that Go runs on
+func init() {
+	Supported = append(Supported, Kernels{
+		Name:           "neon",
+		Dot:            simdDot(dotNEON),
+		DotNormFloat32: simdDotNorm(dotNormFloat32NEON, dotNormFloat32Generic, 4),
+		DotNormUint8:   simdDotNorm(dotNormUint8NEON, dotNormUint8Generic, 1),
+		DotNormInt8:    simdDotNorm(dotNormInt8NEON, dotNormInt8Generic, 1),
+	})
+	Accelerated = Supported[0]
+}
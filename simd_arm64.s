@@ -0,0 +1,199 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// REDUCE sums the four lanes of a vector register into its low lane
+#define REDUCE(V) \
+	VFADDP V.S4, V.S4, V.S4; \
+	VFADDP V.S4, V.S4, V.S4
+
+// The widening and conversion instructions are encoded by hand for older assemblers
+// WIDEN_UINT8 zero extends the 16 bytes of V8 into the int32 lanes of V11 to V14 and converts them to float32
+// UXTL  V9.H8, V8.B8
+// UXTL2 V10.H8, V8.B16
+// UXTL  V11.S4, V9.H4
+// UXTL2 V12.S4, V9.H8
+// UXTL  V13.S4, V10.H4
+// UXTL2 V14.S4, V10.H8
+// UCVTF V11.S4, V11.S4
+// UCVTF V12.S4, V12.S4
+// UCVTF V13.S4, V13.S4
+// UCVTF V14.S4, V14.S4
+#define WIDEN_UINT8 \
+	WORD $0x2F08A509; \
+	WORD $0x6F08A50A; \
+	WORD $0x2F10A52B; \
+	WORD $0x6F10A52C; \
+	WORD $0x2F10A54D; \
+	WORD $0x6F10A54E; \
+	WORD $0x6E21D96B; \
+	WORD $0x6E21D98C; \
+	WORD $0x6E21D9AD; \
+	WORD $0x6E21D9CE
+
+// WIDEN_INT8 sign extends the 16 bytes of V8 into the int32 lanes of V11 to V14 and converts them to float32
+// SXTL  V9.H8, V8.B8
+// SXTL2 V10.H8, V8.B16
+// SXTL  V11.S4, V9.H4
+// SXTL2 V12.S4, V9.H8
+// SXTL  V13.S4, V10.H4
+// SXTL2 V14.S4, V10.H8
+// SCVTF V11.S4, V11.S4
+// SCVTF V12.S4, V12.S4
+// SCVTF V13.S4, V13.S4
+// SCVTF V14.S4, V14.S4
+#define WIDEN_INT8 \
+	WORD $0x0F08A509; \
+	WORD $0x4F08A50A; \
+	WORD $0x0F10A52B; \
+	WORD $0x4F10A52C; \
+	WORD $0x0F10A54D; \
+	WORD $0x4F10A54E; \
+	WORD $0x4E21D96B; \
+	WORD $0x4E21D98C; \
+	WORD $0x4E21D9AD; \
+	WORD $0x4E21D9CE
+
+
+// func dotNEON(x, y []float32) float32
+// The length of x is a multiple of 16
+TEXT ·dotNEON(SB), NOSPLIT, $0-52
+	MOVD x_base+0(FP), R0
+	MOVD x_len+8(FP), R2
+	MOVD y_base+24(FP), R1
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+	VEOR V2.B16, V2.B16, V2.B16
+	VEOR V3.B16, V3.B16, V3.B16
+	CBZ  R2, done
+
+loop:
+	VLD1.P 64(R0), [V4.S4, V5.S4, V6.S4, V7.S4]
+	VLD1.P 64(R1), [V8.S4, V9.S4, V10.S4, V11.S4]
+	VFMLA  V4.S4, V8.S4, V0.S4
+	VFMLA  V5.S4, V9.S4, V1.S4
+	VFMLA  V6.S4, V10.S4, V2.S4
+	VFMLA  V7.S4, V11.S4, V3.S4
+	SUBS   $16, R2, R2
+	BNE    loop
+
+done:
+	VFADD V1.S4, V0.S4, V0.S4
+	VFADD V3.S4, V2.S4, V2.S4
+	VFADD V2.S4, V0.S4, V0.S4
+	REDUCE(V0)
+	FMOVS F0, ret+48(FP)
+	RET
+
+// func dotNormFloat32NEON(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many big endian float32s
+TEXT ·dotNormFloat32NEON(SB), NOSPLIT, $0-56
+	MOVD x_base+0(FP), R0
+	MOVD x_len+8(FP), R2
+	MOVD y_base+24(FP), R1
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+	VEOR V2.B16, V2.B16, V2.B16
+	VEOR V3.B16, V3.B16, V3.B16
+	CBZ  R2, done
+
+loop:
+	VLD1.P 64(R0), [V4.S4, V5.S4, V6.S4, V7.S4]
+	VLD1.P 64(R1), [V8.B16, V9.B16, V10.B16, V11.B16]
+	VREV32 V8.B16, V8.B16
+	VREV32 V9.B16, V9.B16
+	VREV32 V10.B16, V10.B16
+	VREV32 V11.B16, V11.B16
+	VFMLA  V4.S4, V8.S4, V0.S4
+	VFMLA  V5.S4, V9.S4, V1.S4
+	VFMLA  V6.S4, V10.S4, V0.S4
+	VFMLA  V7.S4, V11.S4, V1.S4
+	VFMLA  V8.S4, V8.S4, V2.S4
+	VFMLA  V9.S4, V9.S4, V3.S4
+	VFMLA  V10.S4, V10.S4, V2.S4
+	VFMLA  V11.S4, V11.S4, V3.S4
+	SUBS   $16, R2, R2
+	BNE    loop
+
+done:
+	VFADD V1.S4, V0.S4, V0.S4
+	VFADD V3.S4, V2.S4, V2.S4
+	REDUCE(V0)
+	REDUCE(V2)
+	FMOVS F0, xy+48(FP)
+	FMOVS F2, yy+52(FP)
+	RET
+
+// func dotNormUint8NEON(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many bytes
+TEXT ·dotNormUint8NEON(SB), NOSPLIT, $0-56
+	MOVD x_base+0(FP), R0
+	MOVD x_len+8(FP), R2
+	MOVD y_base+24(FP), R1
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+	VEOR V2.B16, V2.B16, V2.B16
+	VEOR V3.B16, V3.B16, V3.B16
+	CBZ  R2, done
+
+loop:
+	VLD1.P 16(R1), [V8.B16]
+	WIDEN_UINT8
+	VLD1.P 64(R0), [V4.S4, V5.S4, V6.S4, V7.S4]
+	VFMLA  V4.S4, V11.S4, V0.S4
+	VFMLA  V5.S4, V12.S4, V1.S4
+	VFMLA  V6.S4, V13.S4, V0.S4
+	VFMLA  V7.S4, V14.S4, V1.S4
+	VFMLA  V11.S4, V11.S4, V2.S4
+	VFMLA  V12.S4, V12.S4, V3.S4
+	VFMLA  V13.S4, V13.S4, V2.S4
+	VFMLA  V14.S4, V14.S4, V3.S4
+	SUBS   $16, R2, R2
+	BNE    loop
+
+done:
+	VFADD V1.S4, V0.S4, V0.S4
+	VFADD V3.S4, V2.S4, V2.S4
+	REDUCE(V0)
+	REDUCE(V2)
+	FMOVS F0, xy+48(FP)
+	FMOVS F2, yy+52(FP)
+	RET
+
+// func dotNormInt8NEON(x []float32, y []byte) (xy, yy float32)
+// The length of x is a multiple of 16 and y holds as many bytes
+TEXT ·dotNormInt8NEON(SB), NOSPLIT, $0-56
+	MOVD x_base+0(FP), R0
+	MOVD x_len+8(FP), R2
+	MOVD y_base+24(FP), R1
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+	VEOR V2.B16, V2.B16, V2.B16
+	VEOR V3.B16, V3.B16, V3.B16
+	CBZ  R2, done
+
+loop:
+	VLD1.P 16(R1), [V8.B16]
+	WIDEN_INT8
+	VLD1.P 64(R0), [V4.S4, V5.S4, V6.S4, V7.S4]
+	VFMLA  V4.S4, V11.S4, V0.S4
+	VFMLA  V5.S4, V12.S4, V1.S4
+	VFMLA  V6.S4, V13.S4, V0.S4
+	VFMLA  V7.S4, V14.S4, V1.S4
+	VFMLA  V11.S4, V11.S4, V2.S4
+	VFMLA  V12.S4, V12.S4, V3.S4
+	VFMLA  V13.S4, V13.S4, V2.S4
+	VFMLA  V14.S4, V14.S4, V3.S4
+	SUBS   $16, R2, R2
+	BNE    loop
+
+done:
+	VFADD V1.S4, V0.S4, V0.S4
+	VFADD V3.S4, V2.S4, V2.S4
+	REDUCE(V0)
+	REDUCE(V2)
+	FMOVS F0, xy+48(FP)
+	FMOVS F2, yy+52(FP)
+	RET
@@ -0,0 +1,35 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build amd64 || arm64
+
+package main
+
+// Lanes is the multiple of elements the assembly kernels work on, the rest are left to the generic kernels
+const Lanes = 16
+
+// simdDot runs the dot product kernel on the largest multiple of Lanes elements
+func simdDot(kernel func(x, y []float32) float32) func(x, y []float32) float32 {
+	return func(x, y []float32) float32 {
+		n := len(x) &^ (Lanes - 1)
+		z := float32(0.0)
+		if n > 0 {
+			z = kernel(x[:n], y[:n])
+		}
+		return z + dotGeneric(x[n:], y[n:len(x)])
+	}
+}
+
+// simdDotNorm runs the dot product and norm kernel on the largest multiple of Lanes elements,
+// each of which is width bytes of y
+func simdDotNorm(kernel, generic func(x []float32, y []byte) (xy, yy float32), width int) func(x []float32, y []byte) (xy, yy float32) {
+	return func(x []float32, y []byte) (xy, yy float32) {
+		n := len(x) &^ (Lanes - 1)
+		if n > 0 {
+			xy, yy = kernel(x[:n], y[:width*n])
+		}
+		a, b := generic(x[n:], y[width*n:width*len(x)])
+		return xy + a, yy + b
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// vectors makes a random float32 vector of length n, a random float32 vector encoded as big endian
+// float32s, and random bytes, each allocated to end exactly at its length
+func vectors(rng *rand.Rand, n int) (x, y []float32, encoded, quantized []byte) {
+	x, y, encoded, quantized = make([]float32, n), make([]float32, n), make([]byte, 4*n), make([]byte, n)
+	for i := range x {
+		x[i], y[i] = 2*rng.Float32()-1, 2*rng.Float32()-1
+		binary.BigEndian.PutUint32(encoded[4*i:], math.Float32bits(y[i]))
+		quantized[i] = byte(rng.Intn(256))
+	}
+	return x, y, encoded, quantized
+}
+
+// near is true if a is b within a relative error that grows with the length of the sum
+func near(a, b float32, n int) bool {
+	tolerance := 1e-5 * float64(n+1)
+	return math.Abs(float64(a-b)) <= tolerance*max(math.Abs(float64(b)), 1)
+}
+
+func TestKernels(t *testing.T) {
+	if len(Supported) == 0 {
+		t.Skip("the cpu supports no SIMD kernels")
+	}
+	rng := rand.New(rand.NewSource(1))
+	lengths := []int{0, 1, 15, 16, 17, 31, 32, 33, 63, 64, 65, 255, 256, 257, 1024}
+	for i := 0; i < 32; i++ {
+		lengths = append(lengths, rng.Intn(600))
+	}
+	for _, kernels := range Supported {
+		for _, n := range lengths {
+			x, y, encoded, quantized := vectors(rng, n)
+			if a, b := kernels.Dot(x, y), Generic.Dot(x, y); !near(a, b, n) {
+				t.Fatalf("%s dot of length %d: %g, expected %g", kernels.Name, n, a, b)
+			}
+			norms := []struct {
+				Name    string
+				Kernel  func(x []float32, y []byte) (xy, yy float32)
+				Generic func(x []float32, y []byte) (xy, yy float32)
+				Y       []byte
+			}{
+				{"float32", kernels.DotNormFloat32, Generic.DotNormFloat32, encoded},
+				{"uint8", kernels.DotNormUint8, Generic.DotNormUint8, quantized},
+				{"int8", kernels.DotNormInt8, Generic.DotNormInt8, quantized},
+			}
+			for _, norm := range norms {
+				xy, yy := norm.Kernel(x, norm.Y)
+				expectedXY, expectedYY := norm.Generic(x, norm.Y)
+				if !near(xy, expectedXY, n) || !near(yy, expectedYY, n) {
+					t.Fatalf("%s %s dot norm of length %d: %g %g, expected %g %g",
+						kernels.Name, norm.Name, n, xy, yy, expectedXY, expectedYY)
+				}
+			}
+		}
+	}
+}
+
+// benchmark runs a benchmark of the generic kernels and of each of the supported kernels on vectors
+// the size of the embeddings
+func benchmark(b *testing.B, kernel func(k *Kernels, x, y []float32, encoded, quantized []byte)) {
+	x, y, encoded, quantized := vectors(rand.New(rand.NewSource(1)), 256)
+	for _, kernels := range append([]Kernels{Generic}, Supported...) {
+		b.Run(kernels.Name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				kernel(&kernels, x, y, encoded, quantized)
+			}
+		})
+	}
+}
+
+func BenchmarkDot(b *testing.B) {
+	benchmark(b, func(k *Kernels, x, y []float32, _, _ []byte) {
+		k.Dot(x, y)
+	})
+}
+
+func BenchmarkDotNormFloat32(b *testing.B) {
+	benchmark(b, func(k *Kernels, x, _ []float32, encoded, _ []byte) {
+		k.DotNormFloat32(x, encoded)
+	})
+}
+
+func BenchmarkDotNormUint8(b *testing.B) {
+	benchmark(b, func(k *Kernels, x, _ []float32, _, quantized []byte) {
+		k.DotNormUint8(x, quantized)
+	})
+}
+
+func BenchmarkDotNormInt8(b *testing.B) {
+	benchmark(b, func(k *Kernels, x, _ []float32, _, quantized []byte) {
+		k.DotNormInt8(x, quantized)
+	})
+}
@@ -0,0 +1,293 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"os"
+	"sort"
+)
+
+// ByMarkov orders records by markov context and then by position
+func ByMarkov(a, b *TXT) bool {
+	if a.Markov[0] != b.Markov[0] {
+		return a.Markov[0] < b.Markov[0]
+	} else if a.Markov[1] != b.Markov[1] {
+		return a.Markov[1] < b.Markov[1]
+	}
+	return a.Index < b.Index
+}
+
+// ByRank orders records by markov context and then by descending rank
+func ByRank(a, b *TXT) bool {
+	if a.Markov[0] != b.Markov[0] {
+		return a.Markov[0] < b.Markov[0]
+	} else if a.Markov[1] != b.Markov[1] {
+		return a.Markov[1] < b.Markov[1]
+	} else if a.Rank != b.Rank {
+		return a.Rank > b.Rank
+	}
+	return a.Index < b.Index
+}
+
+// FanIn is the largest number of runs merged at once, which bounds the number of files open during a merge
+const FanIn = 256
+
+// Sorter is an external memory sorter for txt records
+// Records are buffered in memory and spilled to temporary files as sorted runs, which are then k-way merged,
+// at most FanIn at a time, so many runs are merged into fewer longer runs before the final merge
+// The runs hold full precision vectors, or vectors encoded by the codec if there is one
+// Less may only compare the fields other than the vector, since the runs are merged without decoding the vectors
+type Sorter struct {
+	Less  func(a, b *TXT) bool
+	Size  int
+	Dir   string
+	Codec *Codec
+	Count uint64
+	txts  []TXT
+	runs  []string
+}
+
+// NewSorter makes a new sorter that holds at most size records in memory
+func NewSorter(dir string, size int, less func(a, b *TXT) bool) *Sorter {
+	if size < 1 {
+		size = 1
+	}
+	return &Sorter{
+		Less: less,
+		Size: size,
+		Dir:  dir,
+	}
+}
+
+// Add adds a record to the sorter
+func (s *Sorter) Add(txt *TXT) error {
+	s.txts = append(s.txts, *txt)
+	s.Count++
+	if len(s.txts) >= s.Size {
+		return s.spill()
+	}
+	return nil
+}
+
+func (s *Sorter) sort() {
+	sort.Slice(s.txts, func(i, j int) bool {
+		return s.Less(&s.txts[i], &s.txts[j])
+	})
+}
+
+// line is the size of a record in the runs
+func (s *Sorter) line() int {
+	if s.Codec == nil {
+		return Line
+	}
+	return s.Codec.Encoding.Line()
+}
+
+// encode encodes a record into a buffer of line bytes
+func (s *Sorter) encode(txt *TXT, buffer []byte) {
+	if s.Codec == nil {
+		txt.Encode(buffer)
+		return
+	}
+	width := s.Codec.Encoding.Width()
+	s.Codec.Encode(&txt.Vector, buffer[:width])
+	txt.EncodeMeta(buffer[width:])
+}
+
+// decode decodes a record from a buffer of line bytes, skipping the vector unless vectors is true
+func (s *Sorter) decode(buffer []byte, txt *TXT, vectors bool) {
+	width := len(buffer) - Meta
+	if vectors && s.Codec == nil {
+		txt.Decode(buffer)
+		return
+	} else if vectors {
+		s.Codec.Decode(buffer[:width], &txt.Vector)
+	}
+	txt.DecodeMeta(buffer[width:])
+}
+
+// write writes a run to a new temporary file with a function that is given a writer of the run
+func (s *Sorter) write(f func(writer *bufio.Writer) error) error {
+	run, err := os.CreateTemp(s.Dir, "txt-run-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, run.Name())
+	writer := bufio.NewWriter(run)
+	err = f(writer)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closed := run.Close(); err == nil {
+		err = closed
+	}
+	return err
+}
+
+func (s *Sorter) spill() error {
+	s.sort()
+	err := s.write(func(writer *bufio.Writer) error {
+		buffer := make([]byte, s.line())
+		for i := range s.txts {
+			s.encode(&s.txts[i], buffer)
+			if _, err := writer.Write(buffer); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.txts = s.txts[:0]
+	return err
+}
+
+// run is the head of a sorted run during the merge
+type run struct {
+	TXT    TXT
+	Line   []byte
+	Reader *bufio.Reader
+}
+
+// runs is a min heap of sorted runs
+type runs struct {
+	Runs []*run
+	less func(a, b *TXT) bool
+}
+
+func (r *runs) Len() int           { return len(r.Runs) }
+func (r *runs) Less(i, j int) bool { return r.less(&r.Runs[i].TXT, &r.Runs[j].TXT) }
+func (r *runs) Swap(i, j int)      { r.Runs[i], r.Runs[j] = r.Runs[j], r.Runs[i] }
+func (r *runs) Push(x any)         { r.Runs = append(r.Runs, x.(*run)) }
+func (r *runs) Pop() any {
+	last := r.Runs[len(r.Runs)-1]
+	r.Runs = r.Runs[:len(r.Runs)-1]
+	return last
+}
+
+// Merge calls f with every record in sorted order
+// With a codec the vectors of the records that were spilled have been through the codec
+func (s *Sorter) Merge(f func(txt *TXT) error) error {
+	return s.merge(func(txt *TXT, _ []byte) error {
+		return f(txt)
+	}, true)
+}
+
+// MergeLines calls f with every record in sorted order encoded as it is in the runs
+func (s *Sorter) MergeLines(f func(line []byte) error) error {
+	buffer := make([]byte, s.line())
+	return s.merge(func(txt *TXT, line []byte) error {
+		if line == nil {
+			s.encode(txt, buffer)
+			line = buffer
+		}
+		return f(line)
+	}, false)
+}
+
+// merge calls f with every record in sorted order, and its line if it was spilled
+func (s *Sorter) merge(f func(txt *TXT, line []byte) error, vectors bool) error {
+	if len(s.runs) == 0 {
+		s.sort()
+		for i := range s.txts {
+			if err := f(&s.txts[i], nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(s.txts) > 0 {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+	s.txts = nil
+
+	for len(s.runs) > FanIn {
+		names := s.runs[:FanIn]
+		err := s.write(func(writer *bufio.Writer) error {
+			return s.mergeRuns(names, func(_ *TXT, line []byte) error {
+				_, err := writer.Write(line)
+				return err
+			}, false)
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+		s.runs = s.runs[FanIn:]
+	}
+	return s.mergeRuns(s.runs, f, vectors)
+}
+
+// mergeRuns calls f with every record of the named runs in sorted order and its line
+func (s *Sorter) mergeRuns(names []string, f func(txt *TXT, line []byte) error, vectors bool) error {
+	next := func(r *run) (bool, error) {
+		_, err := io.ReadFull(r.Reader, r.Line)
+		if err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		s.decode(r.Line, &r.TXT, vectors)
+		return true, nil
+	}
+	h := &runs{
+		Runs: make([]*run, 0, len(names)),
+		less: s.Less,
+	}
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r := &run{
+			Line:   make([]byte, s.line()),
+			Reader: bufio.NewReader(file),
+		}
+		ok, err := next(r)
+		if err != nil {
+			return err
+		}
+		if ok {
+			h.Runs = append(h.Runs, r)
+		}
+	}
+	heap.Init(h)
+	for h.Len() > 0 {
+		r := h.Runs[0]
+		if err := f(&r.TXT, r.Line); err != nil {
+			return err
+		}
+		ok, err := next(r)
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+	return nil
+}
+
+// Close removes the temporary files of the sorter
+func (s *Sorter) Close() error {
+	var first error
+	for _, name := range s.runs {
+		if err := os.Remove(name); err != nil && first == nil {
+			first = err
+		}
+	}
+	s.runs, s.txts = nil, nil
+	return first
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 The TXT Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+)
+
+// records makes count random records with few markov contexts, so many records share one
+func records(rng *rand.Rand, count int) []TXT {
+	txts := make([]TXT, count)
+	for i := range txts {
+		txt := &txts[i]
+		for j := range txt.Vector {
+			txt.Vector[j] = rng.Float32()
+		}
+		txt.Markov = Markov{byte(rng.Intn(3)), byte(rng.Intn(3))}
+		txt.Symbol, txt.Index, txt.Rank = byte(rng.Intn(256)), uint64(i), rng.Float64()
+	}
+	return txts
+}
+
+func TestSorter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{1, 7, 1 << 20} {
+		for _, less := range []func(a, b *TXT) bool{ByMarkov, ByRank} {
+			dir := t.TempDir()
+			txts := records(rng, 3*FanIn+11)
+			sorter := NewSorter(dir, size, less)
+			for i := range txts {
+				if err := sorter.Add(&txts[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			sort.Slice(txts, func(i, j int) bool {
+				return less(&txts[i], &txts[j])
+			})
+			i := 0
+			err := sorter.Merge(func(txt *TXT) error {
+				if *txt != txts[i] {
+					t.Fatalf("size %d: record %d is out of order", size, i)
+				}
+				i++
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if i != len(txts) {
+				t.Fatalf("size %d: merged %d of %d records", size, i, len(txts))
+			}
+			if err := sorter.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if entries, err := os.ReadDir(dir); err != nil || len(entries) > 0 {
+				t.Fatalf("size %d: %d runs were left behind: %v", size, len(entries), err)
+			}
+		}
+	}
+}
+
+func TestSorterLines(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	codec := &Codec{
+		Encoding: EncodingInt8,
+	}
+	for _, size := range []int{1, 5, 1 << 20} {
+		txts := records(rng, 2*FanIn+3)
+		sorter := NewSorter(t.TempDir(), size, ByRank)
+		sorter.Codec = codec
+		for i := range txts {
+			if err := sorter.Add(&txts[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sort.Slice(txts, func(i, j int) bool {
+			return ByRank(&txts[i], &txts[j])
+		})
+		expected, i := make([]byte, codec.Encoding.Line()), 0
+		err := sorter.MergeLines(func(line []byte) error {
+			width := codec.Encoding.Width()
+			codec.Encode(&txts[i].Vector, expected[:width])
+			txts[i].EncodeMeta(expected[width:])
+			if string(line) != string(expected) {
+				t.Fatalf("size %d: line %d is out of order or wrongly encoded", size, i)
+			}
+			i++
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i != len(txts) {
+			t.Fatalf("size %d: merged %d of %d lines", size, i, len(txts))
+		}
+		if err := sorter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}